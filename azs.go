@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+)
+
+// zoneLister returns the availability zones in a region
+type zoneLister func(region string) ([]fetcher.Zone, error)
+
+var reAzLetter = regexp.MustCompile(`^[a-z]$`)
+
+// parseAvailabilityZones resolves a comma delimited list of availability zones into full
+// zone names. Each value can be a full name (us-east-1a), a zone id (use1-az4) or a letter
+// (a) that is applied to every region. Values are validated against the zones in each
+// region, and every region must be left with at least one zone.
+func parseAvailabilityZones(regions []string, azsFlag string, list zoneLister) ([]string, error) {
+	values := []string{}
+	for _, s := range strings.Split(azsFlag, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	byRegion := map[string][]fetcher.Zone{}
+	for _, region := range regions {
+		zones, err := list(region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list availability zones in %s: %v", region, err)
+		}
+		byRegion[region] = zones
+	}
+
+	selected := map[string][]string{}
+	add := func(region, name string) {
+		if !containsString(selected[region], name) {
+			selected[region] = append(selected[region], name)
+		}
+	}
+
+	for _, v := range values {
+		if reAzLetter.MatchString(v) {
+			for _, region := range regions {
+				zone, ok := findZone(byRegion[region], func(z fetcher.Zone) bool { return z.Name == region+v })
+				if !ok {
+					return nil, fmt.Errorf("%s%s isn't an availability zone, %s has %s",
+						region, v, region, zoneNames(byRegion[region]))
+				}
+				add(region, zone.Name)
+			}
+			continue
+		}
+
+		found := false
+		for _, region := range regions {
+			zone, ok := findZone(byRegion[region], func(z fetcher.Zone) bool { return z.Name == v || z.ID == v })
+			if ok {
+				add(region, zone.Name)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("%s isn't an availability zone name or id in %s", v, strings.Join(regions, ","))
+		}
+	}
+
+	azs := []string{}
+	for _, region := range regions {
+		if len(selected[region]) == 0 {
+			return nil, fmt.Errorf("no availability zones selected in %s, it has %s",
+				region, zoneNames(byRegion[region]))
+		}
+		azs = append(azs, selected[region]...)
+	}
+
+	return azs, nil
+}
+
+func findZone(zones []fetcher.Zone, match func(fetcher.Zone) bool) (fetcher.Zone, bool) {
+	for _, z := range zones {
+		if match(z) {
+			return z, true
+		}
+	}
+	return fetcher.Zone{}, false
+}
+
+func zoneNames(zones []fetcher.Zone) string {
+	names := []string{}
+	for _, z := range zones {
+		names = append(names, z.Name)
+	}
+	if len(names) == 0 {
+		return "no zones"
+	}
+	return strings.Join(names, ",")
+}
+
+// pricedZones lists the availability zones that have prices, for when AWS isn't available.
+// Zone ids aren't known this way.
+func pricedZones(prices data.SpotPriceSlice) zoneLister {
+	return func(region string) ([]fetcher.Zone, error) {
+		names := prices.ByRegion(region).AvailabilityZones()
+		sort.Strings(names)
+
+		zones := []fetcher.Zone{}
+		for _, az := range names {
+			zones = append(zones, fetcher.Zone{Name: az, Region: region})
+		}
+		return zones, nil
+	}
+}
+
+// awsZones is the output of `aws ec2 describe-availability-zones`
+type awsZones struct {
+	AvailabilityZones []struct {
+		ZoneName   string
+		ZoneId     string
+		RegionName string
+	}
+}
+
+// loadZoneMap reads zone names and ids for offline use from a file in the format output by
+// `aws ec2 describe-availability-zones`, with zones from any number of regions
+func loadZoneMap(path string) (zoneLister, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var output awsZones
+	if err := json.NewDecoder(f).Decode(&output); err != nil {
+		return nil, fmt.Errorf("failed to read zone map %s: %v", path, err)
+	}
+
+	byRegion := map[string][]fetcher.Zone{}
+	for _, z := range output.AvailabilityZones {
+		region := z.RegionName
+		if region == "" {
+			region = data.RegionFromAvailabilityZone(z.ZoneName)
+		}
+		byRegion[region] = append(byRegion[region], fetcher.Zone{Name: z.ZoneName, ID: z.ZoneId, Region: region})
+	}
+
+	for _, zones := range byRegion {
+		sort.Slice(zones, func(i, j int) bool {
+			return zones[i].Name < zones[j].Name
+		})
+	}
+
+	return func(region string) ([]fetcher.Zone, error) {
+		return byRegion[region], nil
+	}, nil
+}
+
+// withFallback lists zones with list, using fallback when it fails or doesn't know zone ids
+func withFallback(list, fallback zoneLister) zoneLister {
+	return func(region string) ([]fetcher.Zone, error) {
+		zones, err := list(region)
+		if err == nil && (len(zones) == 0 || zones[0].ID != "") {
+			return zones, nil
+		}
+
+		if fallbackZones, fallbackErr := fallback(region); fallbackErr == nil && len(fallbackZones) > 0 {
+			return fallbackZones, nil
+		}
+
+		return zones, err
+	}
+}
+
+// zoneIDs maps the names of the zones in regions to their ids
+func zoneIDs(regions []string, list zoneLister) (map[string]string, error) {
+	ids := map[string]string{}
+
+	for _, region := range regions {
+		zones, err := list(region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list availability zones in %s: %v", region, err)
+		}
+
+		for _, z := range zones {
+			if z.ID == "" {
+				return nil, fmt.Errorf("no zone id known for %s, try -zone-map", z.Name)
+			}
+			ids[z.Name] = z.ID
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+)
+
+func fakeZones(region string) ([]fetcher.Zone, error) {
+	switch region {
+	case "us-east-1":
+		return []fetcher.Zone{
+			{Name: "us-east-1a", ID: "use1-az4", Region: region},
+			{Name: "us-east-1b", ID: "use1-az6", Region: region},
+		}, nil
+	case "us-west-1":
+		return []fetcher.Zone{
+			{Name: "us-west-1b", ID: "usw1-az3", Region: region},
+			{Name: "us-west-1c", ID: "usw1-az1", Region: region},
+		}, nil
+	}
+	return nil, nil
+}
+
+func TestParseAvailabilityZones(t *testing.T) {
+	for _, tc := range []struct {
+		Regions  []string
+		Flag     string
+		Expected []string
+	}{
+		{[]string{"us-east-1"}, "", nil},
+		{[]string{"us-east-1", "us-west-1"}, "b", []string{"us-east-1b", "us-west-1b"}},
+		{[]string{"us-east-1", "us-west-1"}, "us-east-1a,usw1-az1", []string{"us-east-1a", "us-west-1c"}},
+		{[]string{"us-east-1"}, "a,use1-az4", []string{"us-east-1a"}},
+	} {
+		azs, err := parseAvailabilityZones(tc.Regions, tc.Flag, fakeZones)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(azs, tc.Expected) {
+			t.Fatalf("Expected %v for %q, got %v", tc.Expected, tc.Flag, azs)
+		}
+	}
+}
+
+func TestParseAvailabilityZonesValidates(t *testing.T) {
+	for _, tc := range []struct {
+		Regions []string
+		Flag    string
+	}{
+		{[]string{"us-east-1", "us-west-1"}, "a"},
+		{[]string{"us-east-1"}, "us-east-1z"},
+		{[]string{"us-east-1", "us-west-1"}, "us-east-1a"},
+	} {
+		if _, err := parseAvailabilityZones(tc.Regions, tc.Flag, fakeZones); err == nil {
+			t.Fatalf("Expected an error for %q in %v", tc.Flag, tc.Regions)
+		}
+	}
+}
+
+func TestZoneMapFallback(t *testing.T) {
+	f, err := ioutil.TempFile("", "zones")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`{"AvailabilityZones": [
+		{"ZoneName": "us-east-1b", "ZoneId": "use1-az2", "RegionName": "us-east-1"},
+		{"ZoneName": "us-east-1a", "ZoneId": "use1-az6", "RegionName": "us-east-1"}
+	]}`)
+	f.Close()
+
+	zoneMap, err := loadZoneMap(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offline := pricedZones(data.SpotPriceSlice{
+		{Region: "us-east-1", AvailabilityZone: "us-east-1a"},
+	})
+
+	ids, err := zoneIDs([]string{"us-east-1"}, withFallback(offline, zoneMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids["us-east-1a"] != "use1-az6" || ids["us-east-1b"] != "use1-az2" {
+		t.Fatalf("Expected ids from the zone map, got %v", ids)
+	}
+
+	if _, err := zoneIDs([]string{"us-east-1"}, offline); err == nil {
+		t.Fatalf("Expected an error without zone ids")
+	}
+}
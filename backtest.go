@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lox/ec2spot/fleet"
+)
+
+func runBacktestCommand(args []string) {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	fetchPools := priceFlags(fs, "Consider")
+	instancesFlag := fs.Int("instances", 10, "How many instances the fleet keeps running")
+	strategyFlag := fs.String("strategy", "all", "Allocation strategies to compare, comma delimited, or all")
+	lookbackFlag := fs.Duration("lookback", time.Hour*24, "How much history stability based strategies judge pools on")
+	maxPriceFlag := fs.Float64("max-price", 100, "Maximum price as a percentage of on-demand, instances are interrupted above it")
+	jsonFlag := fs.Bool("json", false, "Output the results as JSON")
+	configureClient := clientFlags(fs)
+	fs.Parse(args)
+	configureClient()
+
+	strategies := fleet.Strategies
+	if *strategyFlag != "all" {
+		strategies = nil
+		for _, name := range strings.Split(*strategyFlag, ",") {
+			s, err := fleet.ParseStrategy(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+			strategies = append(strategies, s)
+		}
+	}
+
+	prices, params, err := fetchPools()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	all, err := poolHistories(prices, params)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// max prices and savings are relative to on-demand, so pools without one can't be tested
+	histories := []fleet.History{}
+	for _, h := range all {
+		if h.OnDemandPrice == 0 {
+			log.Printf("Skipping %s, no on-demand price is known for its product", h.Pool)
+			continue
+		}
+		histories = append(histories, h)
+	}
+
+	results := []fleet.BacktestResult{}
+	for _, s := range strategies {
+		result, err := fleet.Backtest(histories, s, fleet.BacktestParams{
+			Instances: *instancesFlag,
+			Range:     params.Range,
+			Lookback:  *lookbackFlag,
+			MaxPrice:  *maxPriceFlag / 100,
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		results = append(results, result)
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		showBacktestResults(results, *instancesFlag, len(histories), params.Days)
+	}
+
+	exitIfIncomplete(params)
+}
+
+func showBacktestResults(results []fleet.BacktestResult, instances, pools, days int) {
+	fmt.Printf("%d instances across %d pools over %d days\n\n", instances, pools, days)
+	fmt.Printf("%-26s %12s %12s %9s %14s %13s\n",
+		"Strategy", "Spot Cost", "On-Demand", "Savings", "Interruptions", "Missing Hours")
+
+	for _, r := range results {
+		fmt.Printf("%-26s %12s %12s %8.2f%% %14d %13d\n",
+			r.Strategy, fmt.Sprintf("$%.2f", r.SpotCost), fmt.Sprintf("$%.2f", r.OnDemandCost),
+			r.Savings, r.Interruptions, r.MissingHours)
+	}
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// CommitmentRates are the effective hourly rates of reserved instances and compute savings
+// plans, by region, product then instance type. The instance catalog doesn't include them.
+type CommitmentRates map[string]map[string]map[string]CommitmentRate
+
+// CommitmentRate is the effective hourly rate of each commitment for an instance type, with
+// any upfront payment spread across the term. Zero means the rate isn't known.
+type CommitmentRate struct {
+	Reserved1Year    float64 `json:"reserved_1yr"`
+	Reserved3Year    float64 `json:"reserved_3yr"`
+	SavingsPlan1Year float64 `json:"savings_plan_1yr"`
+	SavingsPlan3Year float64 `json:"savings_plan_3yr"`
+}
+
+// Commitment is a named hourly rate that is paid whether or not the instance runs
+type Commitment struct {
+	Name       string
+	HourlyRate float64
+}
+
+// LoadCommitmentRates reads commitment rates from a json file
+func LoadCommitmentRates(path string) (CommitmentRates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rates, err := ReadCommitmentRates(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commitment rates %s: %v", path, err)
+	}
+	return rates, nil
+}
+
+// ReadCommitmentRates reads commitment rates in the format:
+//
+//	{"us-east-1": {"Linux/UNIX": {"m4.large": {"reserved_1yr": 0.062, "reserved_3yr": 0.042,
+//	  "savings_plan_1yr": 0.07, "savings_plan_3yr": 0.048}}}}
+//
+// Products are named as in spot prices, without the (Amazon VPC) suffix.
+func ReadCommitmentRates(r io.Reader) (CommitmentRates, error) {
+	var rates CommitmentRates
+	if err := json.NewDecoder(r).Decode(&rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// Lookup returns the known commitments for an instance type and product in a region
+func (c CommitmentRates) Lookup(region, product, instanceType string) []Commitment {
+	rate, ok := c[region][strings.TrimSuffix(product, " (Amazon VPC)")][instanceType]
+	if !ok {
+		return nil
+	}
+
+	commitments := []Commitment{}
+	for _, cm := range []Commitment{
+		{"1yr Reserved Instance", rate.Reserved1Year},
+		{"3yr Reserved Instance", rate.Reserved3Year},
+		{"1yr Compute Savings Plan", rate.SavingsPlan1Year},
+		{"3yr Compute Savings Plan", rate.SavingsPlan3Year},
+	} {
+		if cm.HourlyRate > 0 {
+			commitments = append(commitments, cm)
+		}
+	}
+	return commitments
+}
@@ -0,0 +1,34 @@
+package data_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lox/ec2spot/data"
+)
+
+func TestReadCommitmentRates(t *testing.T) {
+	rates, err := data.ReadCommitmentRates(strings.NewReader(`{
+		"us-east-1": {"Linux/UNIX": {"m4.large": {"reserved_1yr": 0.062, "savings_plan_3yr": 0.048}}}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commitments := rates.Lookup("us-east-1", "Linux/UNIX (Amazon VPC)", "m4.large")
+	if len(commitments) != 2 {
+		t.Fatalf("Expected 2 commitments with known rates, got %v", commitments)
+	}
+
+	if c := commitments[1]; c.Name != "3yr Compute Savings Plan" || c.HourlyRate != 0.048 {
+		t.Fatalf("Expected a 3yr savings plan at $0.048, got %v", c)
+	}
+
+	if commitments := rates.Lookup("us-west-2", "Linux/UNIX", "m4.large"); len(commitments) != 0 {
+		t.Fatalf("Expected no commitments in another region, got %v", commitments)
+	}
+
+	if commitments := rates.Lookup("us-east-1", "Windows", "m4.large"); len(commitments) != 0 {
+		t.Fatalf("Expected no commitments for another product, got %v", commitments)
+	}
+}
@@ -0,0 +1,152 @@
+package data
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// awsSpotPrice is a price as output by `aws ec2 describe-spot-price-history`
+type awsSpotPrice struct {
+	AvailabilityZone   string
+	InstanceType       string
+	ProductDescription string
+	SpotPrice          string
+	Timestamp          time.Time
+}
+
+// ImportFile reads spot price history from a .json or .csv file
+func ImportFile(path string) (SpotPriceSlice, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ReadJSON(f)
+	case ".csv":
+		return ReadCSV(f)
+	default:
+		return nil, fmt.Errorf("unknown format for %s, expected .json or .csv", path)
+	}
+}
+
+// ReadJSON reads the output of `aws ec2 describe-spot-price-history`
+func ReadJSON(r io.Reader) (SpotPriceSlice, error) {
+	var output struct {
+		SpotPriceHistory []awsSpotPrice
+	}
+
+	if err := json.NewDecoder(r).Decode(&output); err != nil {
+		return nil, err
+	}
+
+	prices := SpotPriceSlice{}
+	for _, p := range output.SpotPriceHistory {
+		price, err := strconv.ParseFloat(p.SpotPrice, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid spot price %q: %v", p.SpotPrice, err)
+		}
+
+		prices = append(prices, SpotPrice{
+			Region:             RegionFromAvailabilityZone(p.AvailabilityZone),
+			InstanceType:       p.InstanceType,
+			ProductDescription: p.ProductDescription,
+			AvailabilityZone:   p.AvailabilityZone,
+			Price:              price,
+			Timestamp:          p.Timestamp,
+		})
+	}
+
+	return prices, nil
+}
+
+// ReadCSV reads spot price history with a header row naming the columns, e.g as exported
+// from Athena. Column names are matched ignoring case and underscores, so both
+// AvailabilityZone and availability_zone work. A Region column is optional.
+func ReadCSV(r io.Reader) (SpotPriceSlice, error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for idx, name := range header {
+		columns[strings.ToLower(strings.Replace(strings.TrimSpace(name), "_", "", -1))] = idx
+	}
+
+	for _, required := range []string{"availabilityzone", "instancetype", "spotprice", "timestamp"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+
+	get := func(record []string, column string) string {
+		if idx, ok := columns[column]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	prices := SpotPriceSlice{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		price, err := strconv.ParseFloat(get(record, "spotprice"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid spot price: %v", line, err)
+		}
+
+		timestamp, err := parseTimestamp(get(record, "timestamp"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		az := get(record, "availabilityzone")
+		region := get(record, "region")
+		if region == "" {
+			region = RegionFromAvailabilityZone(az)
+		}
+
+		prices = append(prices, SpotPrice{
+			Region:             region,
+			InstanceType:       get(record, "instancetype"),
+			ProductDescription: get(record, "productdescription"),
+			AvailabilityZone:   az,
+			Price:              price,
+			Timestamp:          timestamp,
+		})
+	}
+
+	return prices, nil
+}
+
+var timestampFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, format := range timestampFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
@@ -0,0 +1,75 @@
+package data_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lox/ec2spot/data"
+)
+
+func TestReadJSON(t *testing.T) {
+	input := `{
+    "SpotPriceHistory": [
+        {
+            "AvailabilityZone": "us-east-1a",
+            "InstanceType": "c4.large",
+            "ProductDescription": "Linux/UNIX (Amazon VPC)",
+            "SpotPrice": "0.019800",
+            "Timestamp": "2017-07-01T10:21:09.000Z"
+        },
+        {
+            "AvailabilityZone": "us-east-1b",
+            "InstanceType": "c4.large",
+            "ProductDescription": "Windows",
+            "SpotPrice": "0.111800",
+            "Timestamp": "2017-07-01T10:20:09.000Z"
+        }
+    ]
+}`
+
+	prices, err := data.ReadJSON(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(prices); l != 2 {
+		t.Fatalf("Expected 2 prices, got %d", l)
+	}
+
+	if prices[0].Region != "us-east-1" || prices[0].Price != 0.0198 {
+		t.Fatalf("Unexpected price %#v", prices[0])
+	}
+
+	if l := len(prices.ByProduct("Windows")); l != 1 {
+		t.Fatalf("Expected 1 Windows price, got %d", l)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	input := "availability_zone,instance_type,product_description,spot_price,timestamp\n" +
+		"eu-west-1c,m4.large,Linux/UNIX,0.0321,2017-07-01 10:21:09.000\n" +
+		"eu-west-1a,m4.large,Linux/UNIX,0.0301,2017-07-01T09:21:09Z\n"
+
+	prices, err := data.ReadCSV(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(prices); l != 2 {
+		t.Fatalf("Expected 2 prices, got %d", l)
+	}
+
+	if prices[0].Region != "eu-west-1" || prices[0].AvailabilityZone != "eu-west-1c" {
+		t.Fatalf("Unexpected price %#v", prices[0])
+	}
+
+	if prices[1].Timestamp.Hour() != 9 {
+		t.Fatalf("Expected timestamp at 9am, got %v", prices[1].Timestamp)
+	}
+}
+
+func TestReadCSVRequiresColumns(t *testing.T) {
+	if _, err := data.ReadCSV(strings.NewReader("instance_type,spot_price\n")); err == nil {
+		t.Fatal("Expected an error for missing columns")
+	}
+}
@@ -0,0 +1,166 @@
+package data
+
+import (
+	"sort"
+	"sync"
+)
+
+type selectKey struct {
+	Region       string
+	InstanceType string
+	Product      string
+}
+
+type seriesKey struct {
+	Region             string
+	InstanceType       string
+	ProductDescription string
+	// AvailabilityZone is the zone id when known, otherwise the name
+	AvailabilityZone string
+}
+
+// PriceIndex groups prices by region, instance type, product and availability zone as they arrive,
+// keeping each group sorted by time so that range queries are a binary search
+type PriceIndex struct {
+	sync.Mutex
+	series   map[seriesKey]SpotPriceSlice
+	unsorted map[seriesKey]bool
+	selected map[selectKey]SpotPriceSlice
+	seen     map[seriesKey]map[int64]struct{}
+	count    int
+}
+
+// NewPriceIndex returns an empty PriceIndex
+func NewPriceIndex() *PriceIndex {
+	return &PriceIndex{
+		series:   map[seriesKey]SpotPriceSlice{},
+		unsorted: map[seriesKey]bool{},
+		selected: map[selectKey]SpotPriceSlice{},
+		seen:     map[seriesKey]map[int64]struct{}{},
+	}
+}
+
+// IndexPrices returns a PriceIndex containing prices
+func IndexPrices(prices SpotPriceSlice) *PriceIndex {
+	idx := NewPriceIndex()
+	for _, p := range prices {
+		idx.Add(p)
+	}
+	return idx
+}
+
+// Add adds a price to the index, sorting is deferred until the series is next read. A price
+// for a zone and time that is already indexed is ignored, as the price in effect at the start
+// of each fetched chunk is returned again by the fetch before it.
+func (idx *PriceIndex) Add(p SpotPrice) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	key := seriesKey{p.Region, p.InstanceType, p.ProductDescription, p.Zone()}
+
+	seen, ok := idx.seen[key]
+	if !ok {
+		seen = map[int64]struct{}{}
+		idx.seen[key] = seen
+	}
+	if _, dup := seen[p.Timestamp.UnixNano()]; dup {
+		return
+	}
+	seen[p.Timestamp.UnixNano()] = struct{}{}
+
+	series := idx.series[key]
+
+	if n := len(series); n > 0 && p.Timestamp.Before(series[n-1].Timestamp) {
+		idx.unsorted[key] = true
+	}
+
+	idx.series[key] = append(series, p)
+	idx.count++
+
+	delete(idx.selected, selectKey{p.Region, p.InstanceType, ""})
+	delete(idx.selected, selectKey{p.Region, p.InstanceType, p.ProductDescription})
+}
+
+// Len returns how many prices are in the index
+func (idx *PriceIndex) Len() int {
+	idx.Lock()
+	defer idx.Unlock()
+	return idx.count
+}
+
+// sorted returns a series, sorting it first if needed. The lock must be held.
+func (idx *PriceIndex) sorted(key seriesKey) SpotPriceSlice {
+	series := idx.series[key]
+
+	if idx.unsorted[key] {
+		sort.SliceStable(series, func(i, j int) bool {
+			return series[i].Timestamp.Before(series[j].Timestamp)
+		})
+		delete(idx.unsorted, key)
+	}
+
+	return series
+}
+
+// Series returns the prices for a single product and availability zone sorted by time
+func (idx *PriceIndex) Series(region, instanceType, product, az string) SpotPriceSlice {
+	idx.Lock()
+	defer idx.Unlock()
+
+	series := idx.sorted(seriesKey{region, instanceType, product, az})
+	return append(SpotPriceSlice{}, series...)
+}
+
+// Select returns the prices across all availability zones for a region, instance type
+// and product sorted by time. An empty product matches all products. The result is cached
+// until more matching prices are added, so it must not be modified.
+func (idx *PriceIndex) Select(region, instanceType, product string) SpotPriceSlice {
+	idx.Lock()
+	defer idx.Unlock()
+
+	sk := selectKey{region, instanceType, product}
+	if merged, ok := idx.selected[sk]; ok {
+		return merged
+	}
+
+	merged := SpotPriceSlice{}
+	for key := range idx.series {
+		if key.Region == region && key.InstanceType == instanceType && (product == "" || key.ProductDescription == product) {
+			merged = append(merged, idx.sorted(key)...)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+
+	// cap the capacity so appending to the result can't overwrite the cache
+	merged = merged[:len(merged):len(merged)]
+	idx.selected[sk] = merged
+
+	return merged
+}
+
+// AvailabilityZones returns the sorted availability zones with prices for a region, instance
+// type and product. Empty values match everything.
+func (idx *PriceIndex) AvailabilityZones(region, instanceType, product string) []string {
+	idx.Lock()
+	defer idx.Unlock()
+
+	seen := map[string]struct{}{}
+	zones := []string{}
+
+	for key := range idx.series {
+		if (region == "" || key.Region == region) &&
+			(instanceType == "" || key.InstanceType == instanceType) &&
+			(product == "" || key.ProductDescription == product) {
+			if _, ok := seen[key.AvailabilityZone]; !ok {
+				seen[key.AvailabilityZone] = struct{}{}
+				zones = append(zones, key.AvailabilityZone)
+			}
+		}
+	}
+
+	sort.Strings(zones)
+	return zones
+}
@@ -0,0 +1,168 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/timerange"
+)
+
+func TestPriceIndexSortsSeries(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+
+	idx := data.NewPriceIndex()
+	for _, h := range []int{5, 1, 3, 2, 4} {
+		for _, az := range []string{"us-east-1a", "us-east-1b"} {
+			idx.Add(data.SpotPrice{
+				Region:           "us-east-1",
+				InstanceType:     "c4.large",
+				AvailabilityZone: az,
+				Price:            float64(h),
+				Timestamp:        t1.Add(time.Duration(h) * time.Hour),
+			})
+		}
+	}
+
+	if l := idx.Len(); l != 10 {
+		t.Fatalf("Expected 10 prices, got %d", l)
+	}
+
+	series := idx.Series("us-east-1", "c4.large", "", "us-east-1b")
+	for i, p := range series {
+		if p.Price != float64(i+1) {
+			t.Fatalf("Expected series sorted by time, got %v at %d", p.Price, i)
+		}
+	}
+
+	all := idx.Select("us-east-1", "c4.large", "")
+	if l := len(all); l != 10 {
+		t.Fatalf("Expected 10 prices, got %d", l)
+	}
+
+	subset := all.Subset(timerange.Range{t1.Add(time.Hour * 2), t1.Add(time.Hour * 3)})
+	if l := len(subset); l != 4 {
+		t.Fatalf("Expected 4 prices between hours 2 and 3, got %d", l)
+	}
+
+	if azs := idx.AvailabilityZones("us-east-1", "c4.large", ""); len(azs) != 2 {
+		t.Fatalf("Expected 2 availability zones, got %v", azs)
+	}
+}
+
+func TestPriceIndexSeparatesProducts(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+
+	idx := data.IndexPrices(data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: "Linux/UNIX", AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: t1},
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: "Windows", AvailabilityZone: "us-east-1a", Price: 0.2, Timestamp: t1},
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: "Windows", AvailabilityZone: "us-east-1b", Price: 0.3, Timestamp: t1},
+	})
+
+	if l := len(idx.Select("us-east-1", "c4.large", "Windows")); l != 2 {
+		t.Fatalf("Expected 2 Windows prices, got %d", l)
+	}
+
+	if series := idx.Series("us-east-1", "c4.large", "Linux/UNIX", "us-east-1a"); len(series) != 1 || series[0].Price != 0.1 {
+		t.Fatalf("Expected a single Linux/UNIX price, got %v", series)
+	}
+
+	if azs := idx.AvailabilityZones("us-east-1", "c4.large", "Linux/UNIX"); len(azs) != 1 {
+		t.Fatalf("Expected 1 availability zone, got %v", azs)
+	}
+}
+
+func TestPriceIndexGroupsByZoneID(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+
+	prices := data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: t1},
+		{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1b", Price: 0.2, Timestamp: t1},
+	}
+
+	idx := data.IndexPrices(prices.WithZoneIDs(map[string]string{
+		"us-east-1a": "use1-az4",
+		"us-east-1b": "use1-az1",
+	}))
+
+	if azs := idx.AvailabilityZones("us-east-1", "c4.large", ""); len(azs) != 2 || azs[0] != "use1-az1" || azs[1] != "use1-az4" {
+		t.Fatalf("Expected zone ids, got %v", azs)
+	}
+
+	series := idx.Series("us-east-1", "c4.large", "", "use1-az4")
+	if len(series) != 1 || series[0].AvailabilityZone != "us-east-1a" {
+		t.Fatalf("Expected us-east-1a keyed by use1-az4, got %v", series)
+	}
+
+	if prices[0].AvailabilityZoneID != "" {
+		t.Fatalf("Expected WithZoneIDs not to modify the original prices")
+	}
+}
+
+func TestPriceIndexSelectSeesPricesAddedAfterCaching(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+
+	idx := data.IndexPrices(data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: "Linux/UNIX", AvailabilityZone: "us-east-1a", Price: 0.2, Timestamp: t1.Add(time.Hour)},
+	})
+
+	if l := len(idx.Select("us-east-1", "c4.large", "")); l != 1 {
+		t.Fatalf("Expected 1 price, got %d", l)
+	}
+
+	idx.Add(data.SpotPrice{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: "Linux/UNIX", AvailabilityZone: "us-east-1b", Price: 0.1, Timestamp: t1})
+
+	for _, product := range []string{"", "Linux/UNIX"} {
+		selected := idx.Select("us-east-1", "c4.large", product)
+		if len(selected) != 2 || selected[0].Price != 0.1 {
+			t.Fatalf("Expected 2 prices sorted by time for %q, got %v", product, selected)
+		}
+	}
+}
+
+func TestSubsetAndBucketsSortUnsortedPrices(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+
+	// newest first, as read from JSON
+	prices := data.SpotPriceSlice{}
+	for h := 5; h >= 0; h-- {
+		prices = append(prices, data.SpotPrice{Price: float64(h), Timestamp: t1.Add(time.Duration(h) * time.Hour)})
+	}
+
+	subset := prices.Subset(timerange.Range{t1.Add(time.Hour), t1.Add(time.Hour * 3)})
+	if len(subset) != 3 || subset[0].Price != 1 {
+		t.Fatalf("Expected hours 1 to 3, got %v", subset)
+	}
+
+	buckets := prices.Buckets([]timerange.Range{
+		{t1, t1.Add(time.Hour)},
+		{t1.Add(time.Hour * 4), t1.Add(time.Hour * 5)},
+	})
+	if len(buckets[0].Prices) != 2 || len(buckets[1].Prices) != 2 {
+		t.Fatalf("Expected 2 prices in each bucket, got %v", buckets)
+	}
+
+	if prices[0].Price != 5 {
+		t.Fatalf("Expected the original slice to be left unsorted")
+	}
+}
+
+func TestPriceIndexIgnoresDuplicatePrices(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+
+	// the price in effect at a chunk boundary is returned by both chunks
+	idx := data.IndexPrices(data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: t1},
+		{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1a", Price: 0.2, Timestamp: t1.Add(time.Hour)},
+		{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1a", Price: 0.2, Timestamp: t1.Add(time.Hour)},
+		{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1b", Price: 0.2, Timestamp: t1.Add(time.Hour)},
+	})
+
+	if l := idx.Len(); l != 3 {
+		t.Fatalf("Expected 3 prices, got %d", l)
+	}
+
+	if l := len(idx.Series("us-east-1", "c4.large", "", "us-east-1a")); l != 2 {
+		t.Fatalf("Expected 2 prices in us-east-1a, got %d", l)
+	}
+}
@@ -1,37 +1,205 @@
 package data
 
 import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
 	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+	catalog "github.com/cristim/ec2-instances-info/data"
 )
 
 var data *ec2instancesinfo.InstanceData
 
+// onDemandPrices maps instance type, region and catalog platform (linux, mswin) to on-demand
+// prices, the catalog library only reads linux prices
+var onDemandPrices map[string]map[string]map[string]float64
+
 func init() {
 	var err error
 	data, err = ec2instancesinfo.Data()
 	if err != nil {
 		panic(err)
 	}
+
+	onDemandPrices, err = readOnDemandPrices()
+	if err != nil {
+		panic(err)
+	}
+}
+
+func readOnDemandPrices() (map[string]map[string]map[string]float64, error) {
+	raw, err := catalog.Asset("data/instances.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []struct {
+		InstanceType string                                `json:"instance_type"`
+		Pricing      map[string]map[string]json.RawMessage `json:"pricing"`
+	}
+	if err := json.Unmarshal(raw, &instances); err != nil {
+		return nil, err
+	}
+
+	prices := map[string]map[string]map[string]float64{}
+	for _, i := range instances {
+		byRegion := map[string]map[string]float64{}
+		for region, platforms := range i.Pricing {
+			byRegion[region] = map[string]float64{}
+			for platform, pricing := range platforms {
+				var p struct {
+					OnDemand string `json:"ondemand"`
+				}
+				// some entries like ebs are a bare price rather than a platform
+				if json.Unmarshal(pricing, &p) != nil || p.OnDemand == "" {
+					continue
+				}
+				if price, err := strconv.ParseFloat(p.OnDemand, 64); err == nil {
+					byRegion[region][platform] = price
+				}
+			}
+		}
+		prices[i.InstanceType] = byRegion
+	}
+
+	return prices, nil
+}
+
+// catalogPlatform returns the catalog's name for the platform of a spot product description
+func catalogPlatform(product string) (string, bool) {
+	switch strings.TrimSuffix(product, " (Amazon VPC)") {
+	case "Linux/UNIX":
+		return "linux", true
+	case "Windows":
+		return "mswin", true
+	}
+	return "", false
 }
 
 type InstanceTypeInfo struct {
 	PrettyName string
 	VCPU       int
 	Memory     float32
-	Price      float64
+	// Price is the on-demand price for the product, zero when the catalog doesn't have one
+	Price float64
 }
 
-func GetInstanceTypeInfo(region, instanceType string) (InstanceTypeInfo, error) {
+// GetInstanceTypeInfo returns the size of an instance type and its on-demand price in a
+// region for a product. The catalog only has on-demand prices for Linux/UNIX and Windows.
+func GetInstanceTypeInfo(region, instanceType, product string) (InstanceTypeInfo, error) {
 	for _, i := range *data {
 		if i.InstanceType == instanceType {
-			return InstanceTypeInfo{
+			info := InstanceTypeInfo{
 				PrettyName: i.PrettyName,
 				VCPU:       i.VCPU,
 				Memory:     i.Memory,
-				Price:      i.Pricing[region].Linux.OnDemand,
-			}, nil
+			}
+			if platform, ok := catalogPlatform(product); ok {
+				info.Price = onDemandPrices[instanceType][region][platform]
+			}
+			return info, nil
 		}
 	}
 
 	return InstanceTypeInfo{}, nil
 }
+
+// InstanceFilter limits which instance types a pattern matches, empty fields match everything
+type InstanceFilter struct {
+	// CurrentGeneration excludes previous generation instance types
+	CurrentGeneration bool
+	// Arch is an architecture like x86_64 or arm64
+	Arch string
+}
+
+// catalogHasArch returns whether any instance type in the catalog has an architecture
+func catalogHasArch(arch string) bool {
+	for _, i := range *data {
+		for _, a := range i.Arch {
+			if a == arch {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f InstanceFilter) matches(generation string, arch []string) bool {
+	if f.CurrentGeneration && generation != "current" {
+		return false
+	}
+
+	if f.Arch == "" {
+		return true
+	}
+
+	for _, a := range arch {
+		if a == f.Arch {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchInstanceTypes expands patterns against the instance catalog, returning the instance
+// types in the order they are matched. A pattern is a glob like c4.* or m4*.large, or a family
+// like family:compute that matches instance families by name. Names without wildcards are
+// returned as is, even if the catalog doesn't know them or the filter would exclude them.
+func MatchInstanceTypes(patterns []string, filter InstanceFilter) ([]string, error) {
+	matched := []string{}
+	seen := map[string]struct{}{}
+
+	add := func(instanceType string) {
+		if _, ok := seen[instanceType]; !ok {
+			seen[instanceType] = struct{}{}
+			matched = append(matched, instanceType)
+		}
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		family := strings.HasPrefix(pattern, "family:")
+		if !family && !strings.ContainsAny(pattern, "*?[") {
+			add(pattern)
+			continue
+		}
+
+		found := []string{}
+		for _, i := range *data {
+			var ok bool
+			if family {
+				ok = strings.Contains(strings.ToLower(i.Family), strings.ToLower(strings.TrimPrefix(pattern, "family:")))
+			} else {
+				var err error
+				if ok, err = path.Match(pattern, i.InstanceType); err != nil {
+					return nil, fmt.Errorf("invalid instance pattern %q: %v", pattern, err)
+				}
+			}
+
+			if ok && filter.matches(i.Generation, i.Arch) {
+				found = append(found, i.InstanceType)
+			}
+		}
+
+		if len(found) == 0 && filter.Arch != "" && !catalogHasArch(filter.Arch) {
+			return nil, fmt.Errorf("the instance catalog has no %s instance types, name them without wildcards instead", filter.Arch)
+		} else if len(found) == 0 {
+			return nil, fmt.Errorf("no instance types match %q", pattern)
+		}
+
+		sort.Strings(found)
+		for _, instanceType := range found {
+			add(instanceType)
+		}
+	}
+
+	return matched, nil
+}
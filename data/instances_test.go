@@ -0,0 +1,111 @@
+package data_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lox/ec2spot/data"
+)
+
+func TestMatchInstanceTypes(t *testing.T) {
+	for _, tc := range []struct {
+		Patterns []string
+		Expected []string
+	}{
+		{[]string{"c4.large", "made.up"}, []string{"c4.large", "made.up"}},
+		{[]string{"c4.*"}, []string{"c4.2xlarge", "c4.4xlarge", "c4.8xlarge", "c4.large", "c4.xlarge"}},
+		{[]string{"m4.large", "m4*.large"}, []string{"m4.large"}},
+	} {
+		matched, err := data.MatchInstanceTypes(tc.Patterns, data.InstanceFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(matched, tc.Expected) {
+			t.Fatalf("Expected %v for %v, got %v", tc.Expected, tc.Patterns, matched)
+		}
+	}
+}
+
+func TestMatchInstanceTypesByFamily(t *testing.T) {
+	all, err := data.MatchInstanceTypes([]string{"family:compute"}, data.InstanceFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := data.MatchInstanceTypes([]string{"family:compute"}, data.InstanceFilter{CurrentGeneration: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(current) == 0 || len(current) >= len(all) {
+		t.Fatalf("Expected current generation to exclude some of %v, got %v", all, current)
+	}
+
+	for _, instanceType := range current {
+		if instanceType[:2] == "c1" {
+			t.Fatalf("Expected no previous generation types, got %s", instanceType)
+		}
+	}
+}
+
+func TestMatchInstanceTypesFiltersByArch(t *testing.T) {
+	i386, err := data.MatchInstanceTypes([]string{"m1.*"}, data.InstanceFilter{Arch: "i386"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := data.MatchInstanceTypes([]string{"m1.*"}, data.InstanceFilter{Arch: "x86_64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(i386) == 0 || len(i386) >= len(all) {
+		t.Fatalf("Expected i386 to match some of %v, got %v", all, i386)
+	}
+
+	for _, instanceType := range i386 {
+		if instanceType == "m1.large" || instanceType == "m1.xlarge" {
+			t.Fatalf("Expected only 32-bit capable types, got %s", instanceType)
+		}
+	}
+}
+
+func TestMatchInstanceTypesFailsWithoutMatches(t *testing.T) {
+	_, err := data.MatchInstanceTypes([]string{"c4.*"}, data.InstanceFilter{Arch: "i386"})
+	if err == nil || !strings.Contains(err.Error(), `no instance types match "c4.*"`) {
+		t.Fatalf("Expected an error when no instance types match, got %v", err)
+	}
+}
+
+func TestMatchInstanceTypesFailsForArchNotInCatalog(t *testing.T) {
+	_, err := data.MatchInstanceTypes([]string{"c4.*"}, data.InstanceFilter{Arch: "arm64"})
+	if err == nil || !strings.Contains(err.Error(), "catalog has no arm64") {
+		t.Fatalf("Expected an error about the catalog, got %v", err)
+	}
+}
+
+func TestGetInstanceTypeInfoPricesEachProduct(t *testing.T) {
+	linux, err := data.GetInstanceTypeInfo("us-east-1", "m4.large", "Linux/UNIX (Amazon VPC)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	windows, err := data.GetInstanceTypeInfo("us-east-1", "m4.large", "Windows")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if linux.Price == 0 || windows.Price <= linux.Price {
+		t.Fatalf("Expected windows to cost more than linux, got $%v and $%v", windows.Price, linux.Price)
+	}
+
+	suse, err := data.GetInstanceTypeInfo("us-east-1", "m4.large", "SUSE Linux")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if suse.Price != 0 || suse.VCPU != 2 {
+		t.Fatalf("Expected no on-demand price for SUSE but the instance size, got %+v", suse)
+	}
+}
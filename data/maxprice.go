@@ -0,0 +1,74 @@
+package data
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lox/ec2spot/timerange"
+)
+
+// MaxPriceSuggestion is the lowest max price that would have kept an instance running for
+// a target share of hours
+type MaxPriceSuggestion struct {
+	MaxPrice     float64 `json:"max_price"`
+	Availability float64 `json:"availability"`
+	Hours        int     `json:"hours"`
+}
+
+// SuggestMaxPrice finds the lowest max price that would have kept an instance running for at
+// least availability (between 0 and 1) of the hours in tr. Each of zones is the prices of a
+// single availability zone, an hour is covered if the price stayed at or below the max
+// price in any of them. Hours before any prices are known are ignored.
+func SuggestMaxPrice(zones []SpotPriceSlice, tr timerange.Range, availability float64) MaxPriceSuggestion {
+	sorted := make([]SpotPriceSlice, len(zones))
+	for idx, z := range zones {
+		sorted[idx] = z.SortByTime()
+	}
+
+	// the cheapest price that would have covered each hour
+	required := []float64{}
+	for _, hour := range tr.Split(time.Hour) {
+		hour[1] = hour[1].Add(-time.Nanosecond)
+
+		cheapest, found := 0.0, false
+		for _, z := range sorted {
+			max, _, ok := z.PriceDuring(hour)
+			if !ok {
+				continue
+			}
+			if !found || max < cheapest {
+				cheapest, found = max, true
+			}
+		}
+
+		if found {
+			required = append(required, cheapest)
+		}
+	}
+
+	if len(required) == 0 {
+		return MaxPriceSuggestion{}
+	}
+
+	sort.Float64s(required)
+
+	needed := int(math.Ceil(availability * float64(len(required))))
+	if needed < 1 {
+		needed = 1
+	} else if needed > len(required) {
+		needed = len(required)
+	}
+
+	suggestion := MaxPriceSuggestion{
+		MaxPrice: required[needed-1],
+		Hours:    len(required),
+	}
+
+	covered := sort.Search(len(required), func(i int) bool {
+		return required[i] > suggestion.MaxPrice
+	})
+	suggestion.Availability = float64(covered) / float64(len(required))
+
+	return suggestion
+}
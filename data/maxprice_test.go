@@ -0,0 +1,49 @@
+package data_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/timerange"
+)
+
+func TestSuggestMaxPrice(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+	tr := timerange.Range{t1, t1.Add(time.Hour * 10)}
+
+	// 0.1 for 9 hours, spiking to 0.5 for the last
+	prices := data.SpotPriceSlice{
+		{Price: 0.1, Timestamp: t1},
+		{Price: 0.5, Timestamp: t1.Add(time.Hour * 9)},
+	}
+
+	if s := data.SuggestMaxPrice([]data.SpotPriceSlice{prices}, tr, 0.9); s.MaxPrice != 0.1 || s.Availability != 0.9 {
+		t.Fatalf("Expected $0.1 covering 90%% of hours, got %+v", s)
+	}
+
+	if s := data.SuggestMaxPrice([]data.SpotPriceSlice{prices}, tr, 0.95); s.MaxPrice != 0.5 || s.Availability != 1 {
+		t.Fatalf("Expected $0.5 covering every hour, got %+v", s)
+	}
+}
+
+func TestSuggestMaxPriceAcrossZones(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+	tr := timerange.Range{t1, t1.Add(time.Hour * 4)}
+
+	// each zone spikes in a different hour, so a low max price covers every hour somewhere
+	a := data.SpotPriceSlice{
+		{Price: 0.1, Timestamp: t1},
+		{Price: 0.9, Timestamp: t1.Add(time.Hour)},
+		{Price: 0.1, Timestamp: t1.Add(time.Hour * 2)},
+	}
+	b := data.SpotPriceSlice{
+		{Price: 0.2, Timestamp: t1},
+		{Price: 0.8, Timestamp: t1.Add(time.Hour * 3)},
+	}
+
+	s := data.SuggestMaxPrice([]data.SpotPriceSlice{a, b}, tr, 1)
+	if s.MaxPrice != 0.2 || s.Hours != 4 {
+		t.Fatalf("Expected $0.2 over 4 hours, got %+v", s)
+	}
+}
@@ -0,0 +1,52 @@
+package data
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Regions returns the sorted regions that the instance catalog has prices for
+func Regions() []string {
+	seen := map[string]struct{}{}
+	regions := []string{}
+
+	for _, i := range *data {
+		for region := range i.Pricing {
+			if _, ok := seen[region]; !ok {
+				seen[region] = struct{}{}
+				regions = append(regions, region)
+			}
+		}
+	}
+
+	sort.Strings(regions)
+	return regions
+}
+
+// OfferedIn returns whether an instance type is offered in a region. Instance types that
+// aren't in the catalog are assumed to be offered everywhere.
+func OfferedIn(region, instanceType string) bool {
+	for _, i := range *data {
+		if i.InstanceType == instanceType {
+			_, ok := i.Pricing[region]
+			return ok
+		}
+	}
+	return true
+}
+
+// regionPattern matches the region at the start of a zone name, e.g us-gov-west-1 in us-gov-west-1a
+// or us-west-2 in the local zone us-west-2-lax-1a
+var regionPattern = regexp.MustCompile(`^[a-z]+(-gov|-iso[a-z]?)?-[a-z]+-[0-9]+`)
+
+// RegionFromAvailabilityZone returns the region an availability zone name is in, e.g us-east-1a
+// is in us-east-1. Local and wavelength zones like us-west-2-lax-1a are in their parent region.
+func RegionFromAvailabilityZone(az string) string {
+	if region := regionPattern.FindString(az); region != "" {
+		return region
+	}
+	if len(az) > 0 && az[len(az)-1] >= 'a' && az[len(az)-1] <= 'z' {
+		return az[:len(az)-1]
+	}
+	return az
+}
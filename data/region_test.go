@@ -0,0 +1,23 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/lox/ec2spot/data"
+)
+
+func TestRegionFromAvailabilityZone(t *testing.T) {
+	for az, expected := range map[string]string{
+		"us-east-1a":              "us-east-1",
+		"ap-southeast-2c":         "ap-southeast-2",
+		"us-gov-west-1a":          "us-gov-west-1",
+		"us-isob-east-1a":         "us-isob-east-1",
+		"us-west-2-lax-1a":        "us-west-2",
+		"us-east-1-wl1-bos-wlz-1": "us-east-1",
+		"us-east-1":               "us-east-1",
+	} {
+		if region := data.RegionFromAvailabilityZone(az); region != expected {
+			t.Fatalf("Expected %s to be in %s, got %s", az, expected, region)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lox/ec2spot/timerange"
+)
+
+// Spike is a period where the spot price was unusually high
+type Spike struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Peak     float64       `json:"peak"`
+	Baseline float64       `json:"baseline"`
+	Duration time.Duration `json:"-"`
+}
+
+// MarshalJSON writes the duration in seconds rather than nanoseconds
+func (s Spike) MarshalJSON() ([]byte, error) {
+	type spike Spike
+	return json.Marshal(struct {
+		spike
+		DurationSeconds float64 `json:"duration_seconds"`
+	}{spike(s), s.Duration.Seconds()})
+}
+
+func (s Spike) String() string {
+	return fmt.Sprintf("%s - %s (%s) peak $%.4g vs baseline $%.4g",
+		s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339), s.Duration, s.Peak, s.Baseline)
+}
+
+// SpikeParams controls what is considered a spike
+type SpikeParams struct {
+	// Window is how far back the rolling median looks
+	Window time.Duration
+	// Factor is how many times the rolling median a price must exceed
+	Factor float64
+	// OnDemand is the on-demand price, any price above it is a spike. Ignored if zero.
+	OnDemand float64
+}
+
+// Spikes finds periods within tr where prices from a single availability zone exceeded the
+// rolling median by the given factor, or exceeded the on-demand price
+func (r SpotPriceSlice) Spikes(tr timerange.Range, params SpikeParams) []Spike {
+	sorted := r.SortByTime()
+	spikes := []Spike{}
+
+	var current *Spike
+	for idx, sp := range sorted {
+		if sp.Timestamp.After(tr[1]) {
+			break
+		}
+
+		if current != nil {
+			if sp.Price > current.Peak {
+				current.Peak = sp.Price
+			}
+			if !params.isSpike(sp.Price, current.Baseline) {
+				current.End = sp.Timestamp
+				spikes = append(spikes, current.finish(tr))
+				current = nil
+			}
+			continue
+		}
+
+		baseline := sorted[:idx].median(timerange.Range{sp.Timestamp.Add(-params.Window), sp.Timestamp})
+		if params.isSpike(sp.Price, baseline) {
+			current = &Spike{Start: sp.Timestamp, Peak: sp.Price, Baseline: baseline}
+		}
+	}
+
+	if current != nil {
+		current.End = tr[1]
+		spikes = append(spikes, current.finish(tr))
+	}
+
+	// drop spikes that finished before the range started
+	filtered := spikes[:0]
+	for _, s := range spikes {
+		if s.End.After(tr[0]) {
+			filtered = append(filtered, s)
+		}
+	}
+
+	return filtered
+}
+
+func (p SpikeParams) isSpike(price, baseline float64) bool {
+	if p.OnDemand > 0 && price > p.OnDemand {
+		return true
+	}
+	return baseline > 0 && p.Factor > 0 && price > baseline*p.Factor
+}
+
+func (s Spike) finish(tr timerange.Range) Spike {
+	if s.Start.Before(tr[0]) {
+		s.Start = tr[0]
+	}
+	s.Duration = s.End.Sub(s.Start)
+	return s
+}
+
+type pricePeriod struct {
+	Price    float64
+	Duration time.Duration
+}
+
+// median returns the price in effect for the middle of tr by time for a time sorted slice,
+// so a brief price change counts for less than one that lasted for hours
+func (r SpotPriceSlice) median(tr timerange.Range) float64 {
+	periods := []pricePeriod{}
+	var total time.Duration
+
+	r.eachPeriod(tr, func(price float64, d time.Duration) {
+		periods = append(periods, pricePeriod{price, d})
+		total += d
+	})
+
+	if total == 0 {
+		return 0
+	}
+
+	sort.Slice(periods, func(i, j int) bool {
+		return periods[i].Price < periods[j].Price
+	})
+
+	var elapsed time.Duration
+	for idx, p := range periods {
+		elapsed += p.Duration
+		if elapsed*2 == total && idx+1 < len(periods) {
+			return (p.Price + periods[idx+1].Price) / 2
+		}
+		if elapsed*2 > total {
+			return p.Price
+		}
+	}
+
+	return periods[len(periods)-1].Price
+}
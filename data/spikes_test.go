@@ -0,0 +1,82 @@
+package data_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/timerange"
+)
+
+func TestSpotPriceSliceSpikes(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+	tr := timerange.Range{t1, t1.AddDate(0, 0, 1)}
+
+	prices := data.SpotPriceSlice{
+		{Price: 0.1, Timestamp: t1.Add(-time.Hour)},
+		{Price: 0.11, Timestamp: t1.Add(time.Hour * 2)},
+		{Price: 0.3, Timestamp: t1.Add(time.Hour * 4)},
+		{Price: 0.5, Timestamp: t1.Add(time.Hour * 5)},
+		{Price: 0.1, Timestamp: t1.Add(time.Hour * 7)},
+		{Price: 1.5, Timestamp: t1.Add(time.Hour * 20)},
+	}
+
+	spikes := prices.Spikes(tr, data.SpikeParams{
+		Window:   time.Hour * 24,
+		Factor:   2,
+		OnDemand: 1.0,
+	})
+
+	if l := len(spikes); l != 2 {
+		t.Fatalf("Expected 2 spikes, got %d", l)
+	}
+
+	if spikes[0].Peak != 0.5 {
+		t.Fatalf("Expected peak of 0.5, got %v", spikes[0].Peak)
+	}
+
+	if spikes[0].Duration != time.Hour*3 {
+		t.Fatalf("Expected spike of 3 hours, got %v", spikes[0].Duration)
+	}
+
+	if !spikes[1].End.Equal(tr[1]) {
+		t.Fatalf("Expected unfinished spike to end at range end, got %v", spikes[1].End)
+	}
+}
+
+func TestSpikeBaselineIsWeightedByTime(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+	tr := timerange.Range{t1, t1.AddDate(0, 0, 1)}
+
+	// most records are brief bumps, but the price spent most of the window at 0.1
+	prices := data.SpotPriceSlice{
+		{Price: 0.1, Timestamp: t1},
+		{Price: 0.3, Timestamp: t1.Add(time.Hour * 20)},
+		{Price: 0.35, Timestamp: t1.Add(time.Hour*20 + time.Minute*10)},
+		{Price: 0.4, Timestamp: t1.Add(time.Hour*20 + time.Minute*20)},
+		{Price: 0.1, Timestamp: t1.Add(time.Hour*20 + time.Minute*30)},
+		{Price: 0.5, Timestamp: t1.Add(time.Hour * 21)},
+	}
+
+	spikes := prices.Spikes(tr, data.SpikeParams{Window: time.Hour * 24, Factor: 2})
+	if l := len(spikes); l != 2 {
+		t.Fatalf("Expected 2 spikes, got %d", l)
+	}
+
+	if last := spikes[len(spikes)-1]; last.Baseline != 0.1 || last.Peak != 0.5 {
+		t.Fatalf("Expected a spike to 0.5 over a baseline of 0.1, got %v", last)
+	}
+}
+
+func TestSpikeJSONDurationInSeconds(t *testing.T) {
+	b, err := json.Marshal(data.Spike{Peak: 0.5, Duration: time.Hour * 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(b), `"duration_seconds":10800`) || !strings.Contains(string(b), `"peak":0.5`) {
+		t.Fatalf("Expected duration in seconds, got %s", b)
+	}
+}
@@ -2,17 +2,29 @@ package data
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/lox/ec2spot/timerange"
 )
 
 type SpotPrice struct {
-	Region           string
-	InstanceType     string
-	AvailabilityZone string
-	Price            float64
-	Timestamp        time.Time
+	Region             string
+	InstanceType       string
+	ProductDescription string
+	AvailabilityZone   string
+	// AvailabilityZoneID is only set when prices are grouped by physical zone, see WithZoneIDs
+	AvailabilityZoneID string
+	Price              float64
+	Timestamp          time.Time
+}
+
+// Zone returns the availability zone id if known, otherwise the account specific name
+func (p SpotPrice) Zone() string {
+	if p.AvailabilityZoneID != "" {
+		return p.AvailabilityZoneID
+	}
+	return p.AvailabilityZone
 }
 
 type SpotPriceSlice []SpotPrice
@@ -51,36 +63,62 @@ func (r SpotPriceSlice) Average() float64 {
 	return total / float64(len(r))
 }
 
+func (r SpotPriceSlice) Latest() time.Time {
+	var latest time.Time
+
+	for _, p := range r {
+		if p.Timestamp.After(latest) {
+			latest = p.Timestamp
+		}
+	}
+
+	return latest
+}
+
+// Buckets splits the prices into each of times, sorting them by time first if needed
 func (r SpotPriceSlice) Buckets(times []timerange.Range) []SpotPriceBucket {
 	var buckets = make([]SpotPriceBucket, len(times))
+	sorted := r.SortByTime()
 
 	for idx, tr := range times {
 		buckets[idx] = SpotPriceBucket{
 			Range:  tr,
-			Prices: r.Subset(tr),
+			Prices: sorted.subset(tr),
 		}
 	}
 
 	return buckets
 }
 
+// Subset returns the prices within tr ordered by time. Already sorted slices, as returned
+// by PriceIndex, are sliced without copying.
 func (r SpotPriceSlice) Subset(tr timerange.Range) SpotPriceSlice {
-	subset := SpotPriceSlice{}
+	return r.SortByTime().subset(tr)
+}
 
-	for _, sp := range r {
-		if tr.Contains(sp.Timestamp) {
-			subset = append(subset, sp)
-		}
+// subset returns the prices within tr from a slice sorted by time
+func (r SpotPriceSlice) subset(tr timerange.Range) SpotPriceSlice {
+	start := sort.Search(len(r), func(i int) bool {
+		return !r[i].Timestamp.Before(tr[0])
+	})
+	end := sort.Search(len(r), func(i int) bool {
+		return r[i].Timestamp.After(tr[1])
+	})
+
+	if start >= end {
+		return SpotPriceSlice{}
 	}
 
-	return subset
+	// cap the capacity so appending to a subset can't overwrite r
+	return r[start:end:end]
 }
 
+// ByAvailabilityZone returns the prices in a zone, matching either its name or id
 func (r SpotPriceSlice) ByAvailabilityZone(az string) SpotPriceSlice {
 	subset := SpotPriceSlice{}
 
 	for _, sp := range r {
-		if sp.AvailabilityZone == az {
+		if sp.AvailabilityZone == az || (sp.AvailabilityZoneID != "" && sp.AvailabilityZoneID == az) {
 			subset = append(subset, sp)
 		}
 	}
@@ -112,20 +150,43 @@ func (r SpotPriceSlice) ByInstanceType(instanceType string) SpotPriceSlice {
 	return subset
 }
 
+func (r SpotPriceSlice) ByProduct(product string) SpotPriceSlice {
+	subset := SpotPriceSlice{}
+
+	for _, sp := range r {
+		if sp.ProductDescription == product {
+			subset = append(subset, sp)
+		}
+	}
+
+	return subset
+}
+
 func (r SpotPriceSlice) AvailabilityZones() []string {
 	zones := []string{}
 	zoneMap := map[string]struct{}{}
 
 	for _, sp := range r {
-		if _, ok := zoneMap[sp.AvailabilityZone]; !ok {
-			zoneMap[sp.AvailabilityZone] = struct{}{}
-			zones = append(zones, sp.AvailabilityZone)
+		if _, ok := zoneMap[sp.Zone()]; !ok {
+			zoneMap[sp.Zone()] = struct{}{}
+			zones = append(zones, sp.Zone())
 		}
 	}
 
 	return zones
 }
 
+// WithZoneIDs returns a copy of the prices with AvailabilityZoneID set from ids, a map of zone
+// names to ids. Zone names differ between accounts, ids identify the same physical zone.
+func (r SpotPriceSlice) WithZoneIDs(ids map[string]string) SpotPriceSlice {
+	result := make(SpotPriceSlice, len(r))
+	for idx, sp := range r {
+		sp.AvailabilityZoneID = ids[sp.AvailabilityZone]
+		result[idx] = sp
+	}
+	return result
+}
+
 func (r SpotPriceSlice) String() string {
 	return fmt.Sprintf("Price range (%d points): Min %.5f Max %.5f Avg %.5f",
 		len(r), r.Min(), r.Max(), r.Average(),
@@ -0,0 +1,188 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/lox/ec2spot/timerange"
+)
+
+// Stability describes how volatile a series of spot prices was over a range
+type Stability struct {
+	ChangesPerDay      float64 `json:"changes_per_day"`
+	MaxHourlyJump      float64 `json:"max_hourly_jump"`
+	TimeAboveThreshold float64 `json:"time_above_threshold"`
+	Score              float64 `json:"score"`
+}
+
+func (s Stability) String() string {
+	return fmt.Sprintf("Changes/day %.2f, Max hourly jump $%.4g, Time above threshold %.2f%%, Score %.1f",
+		s.ChangesPerDay, s.MaxHourlyJump, s.TimeAboveThreshold*100, s.Score)
+}
+
+// SortByTime returns the slice ordered by Timestamp, copying it if it wasn't already sorted
+func (r SpotPriceSlice) SortByTime() SpotPriceSlice {
+	isSorted := sort.SliceIsSorted(r, func(i, j int) bool {
+		return r[i].Timestamp.Before(r[j].Timestamp)
+	})
+	if isSorted {
+		return r
+	}
+
+	sorted := make(SpotPriceSlice, len(r))
+	copy(sorted, r)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	return sorted
+}
+
+// Stability calculates price stability over tr for prices from a single availability zone.
+// The threshold is a fraction of the onDemand price, e.g 0.8 for 80%.
+func (r SpotPriceSlice) Stability(tr timerange.Range, onDemand float64, threshold float64) Stability {
+	var s Stability
+
+	sorted := r.SortByTime()
+	if len(sorted) == 0 {
+		return s
+	}
+
+	var changes int
+	for idx := 1; idx < len(sorted); idx++ {
+		if sorted[idx].Price != sorted[idx-1].Price && tr.Contains(sorted[idx].Timestamp) {
+			changes++
+		}
+	}
+
+	if days := tr[1].Sub(tr[0]).Hours() / 24; days > 0 {
+		s.ChangesPerDay = float64(changes) / days
+	}
+
+	var prev float64
+	for idx, hour := range tr.Split(time.Hour) {
+		max := sorted.MaxDuring(hour)
+		if idx > 0 && prev > 0 && max > 0 {
+			s.MaxHourlyJump = math.Max(s.MaxHourlyJump, math.Abs(max-prev))
+		}
+		prev = max
+	}
+
+	if onDemand > 0 {
+		limit := onDemand * threshold
+		var above time.Duration
+		sorted.eachPeriod(tr, func(price float64, d time.Duration) {
+			if price > limit {
+				above += d
+			}
+		})
+		s.TimeAboveThreshold = float64(above) / float64(tr[1].Sub(tr[0]))
+	}
+
+	s.Score = stabilityScore(s, onDemand)
+	return s
+}
+
+// stabilityScore combines the stability measures into a 0-100 score, higher is more stable.
+// Each change per hour, each jump relative to on-demand and each fraction of time above
+// the threshold reduce the score proportionally.
+func stabilityScore(s Stability, onDemand float64) float64 {
+	score := 100.0
+	score *= 1 / (1 + s.ChangesPerDay/24)
+	score *= 1 - s.TimeAboveThreshold
+
+	if onDemand > 0 {
+		score *= 1 - math.Min(1, s.MaxHourlyJump/onDemand)
+	}
+
+	return score
+}
+
+// TimeWeightedAverage returns the average price in effect during tr for prices from a single
+// availability zone, weighting each price by how long it was in effect
+func (r SpotPriceSlice) TimeWeightedAverage(tr timerange.Range) float64 {
+	return r.SortByTime().timeWeightedAverage(tr)
+}
+
+// timeWeightedAverage is TimeWeightedAverage for a time sorted slice
+func (r SpotPriceSlice) timeWeightedAverage(tr timerange.Range) float64 {
+	var total float64
+	var covered time.Duration
+
+	r.eachPeriod(tr, func(price float64, d time.Duration) {
+		total += price * float64(d)
+		covered += d
+	})
+
+	if covered == 0 {
+		return 0
+	}
+	return total / float64(covered)
+}
+
+// PriceAt returns the price in effect at t for a time sorted slice, or false if no price was set yet
+func (r SpotPriceSlice) PriceAt(t time.Time) (float64, bool) {
+	idx := sort.Search(len(r), func(i int) bool {
+		return r[i].Timestamp.After(t)
+	})
+	if idx == 0 {
+		return 0, false
+	}
+	return r[idx-1].Price, true
+}
+
+// MaxDuring returns the highest price in effect during tr for a time sorted slice
+func (r SpotPriceSlice) MaxDuring(tr timerange.Range) float64 {
+	max, _ := r.PriceAt(tr[0])
+	start := sort.Search(len(r), func(i int) bool {
+		return !r[i].Timestamp.Before(tr[0])
+	})
+
+	for _, sp := range r[start:] {
+		if sp.Timestamp.After(tr[1]) {
+			break
+		}
+		if sp.Price > max {
+			max = sp.Price
+		}
+	}
+
+	return max
+}
+
+// PriceDuring returns the highest price in effect during tr and the time weighted average,
+// for a time sorted slice from a single availability zone, as returned by PriceIndex or
+// SortByTime. It returns false if no price was in effect at any point in tr.
+func (r SpotPriceSlice) PriceDuring(tr timerange.Range) (max, average float64, ok bool) {
+	if _, known := r.PriceAt(tr[0]); !known && len(r.subset(tr)) == 0 {
+		return 0, 0, false
+	}
+	return r.MaxDuring(tr), r.timeWeightedAverage(tr), true
+}
+
+// eachPeriod calls f with each price in effect during tr and how long it was in effect for
+func (r SpotPriceSlice) eachPeriod(tr timerange.Range, f func(price float64, d time.Duration)) {
+	start := tr[0]
+	price, ok := r.PriceAt(start)
+
+	first := sort.Search(len(r), func(i int) bool {
+		return r[i].Timestamp.After(start)
+	})
+
+	for _, sp := range r[first:] {
+		if sp.Timestamp.After(tr[1]) {
+			break
+		}
+		if ok {
+			f(price, sp.Timestamp.Sub(start))
+		}
+		start, price, ok = sp.Timestamp, sp.Price, true
+	}
+
+	if ok && tr[1].After(start) {
+		f(price, tr[1].Sub(start))
+	}
+}
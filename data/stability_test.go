@@ -0,0 +1,65 @@
+package data_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/timerange"
+)
+
+func TestSpotPriceSliceStability(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+	tr := timerange.Range{t1, t1.AddDate(0, 0, 1)}
+
+	prices := data.SpotPriceSlice{
+		{Price: 0.5, Timestamp: t1.Add(time.Hour * 6)},
+		{Price: 0.1, Timestamp: t1.Add(-time.Hour)},
+		{Price: 0.1, Timestamp: t1.Add(time.Hour * 12)},
+	}
+
+	s := prices.Stability(tr, 1.0, 0.4)
+
+	if s.ChangesPerDay != 2 {
+		t.Fatalf("Expected 2 changes per day, got %v", s.ChangesPerDay)
+	}
+
+	if s.MaxHourlyJump != 0.4 {
+		t.Fatalf("Expected max hourly jump of 0.4, got %v", s.MaxHourlyJump)
+	}
+
+	if s.TimeAboveThreshold != 0.25 {
+		t.Fatalf("Expected 25%% of time above threshold, got %v", s.TimeAboveThreshold)
+	}
+
+	if s.Score <= 0 || s.Score >= 100 {
+		t.Fatalf("Expected a score between 0 and 100, got %v", s.Score)
+	}
+}
+
+func TestSpotPriceSliceStabilityOfConstantPrice(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+	tr := timerange.Range{t1, t1.AddDate(0, 0, 1)}
+
+	prices := data.SpotPriceSlice{
+		{Price: 0.1, Timestamp: t1},
+	}
+
+	if s := prices.Stability(tr, 1.0, 0.8); s.Score != 100 {
+		t.Fatalf("Expected a perfect score, got %v", s.Score)
+	}
+}
+
+func TestTimeWeightedAverage(t *testing.T) {
+	t1 := time.Date(2009, time.November, 10, 0, 0, 0, 0, time.UTC)
+	prices := data.SpotPriceSlice{
+		{Price: 0.1, Timestamp: t1},
+		{Price: 0.4, Timestamp: t1.Add(time.Hour * 3)},
+	}
+
+	avg := prices.TimeWeightedAverage(timerange.Range{t1, t1.Add(time.Hour * 4)})
+	if math.Abs(avg-0.175) > 1e-9 {
+		t.Fatalf("Expected average of 0.175, got %v", avg)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+	"github.com/lox/ec2spot/timerange"
+)
+
+func runExporterCommand(args []string) {
+	fs := flag.NewFlagSet("exporter", flag.ExitOnError)
+	parseInstanceTypes := instanceFlags(fs, "Export a particular instance type")
+	productFlag := fs.String("product", "Linux/UNIX (Amazon VPC)", "Export a particular product type, or multiple comma delimited")
+	regionFlag := fs.String("region", "us-east-1", "Export a particular region, multiple comma delimited, a group like us-* or all")
+	concurrencyFlag := fs.Int("concurrency", 10, "How many concurrent AWS requests to make")
+	listenFlag := fs.String("listen", ":9100", "Address to serve /metrics on")
+	intervalFlag := fs.Duration("interval", time.Minute*5, "How often to fetch the latest prices")
+	windowFlag := fs.Duration("window", time.Hour*6, "How far back to look for the latest price")
+	azIDsFlag := fs.Bool("az-ids", false, "Label prices with the availability zone id (e.g use1-az4) rather than the account specific name")
+	configureClient := clientFlags(fs)
+	fs.Parse(args)
+	configureClient()
+
+	instanceTypes, err := parseInstanceTypes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	regions, err := parseRegions(*regionFlag, instanceTypes, knownRegions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	exp := newPriceExporter(exporterParams{
+		InstanceTypes: instanceTypes,
+		Regions:       regions,
+		Products:      strings.Split(*productFlag, ","),
+		Concurrency:   *concurrencyFlag,
+		Window:        *windowFlag,
+		ZoneIDs:       *azIDsFlag,
+	})
+
+	go exp.Run(context.Background(), *intervalFlag)
+
+	http.Handle("/metrics", exp)
+
+	log.Printf("Serving metrics on %s/metrics", *listenFlag)
+	log.Fatal(http.ListenAndServe(*listenFlag, nil))
+}
+
+type exporterParams struct {
+	InstanceTypes []string
+	Regions       []string
+	Products      []string
+	Concurrency   int
+	Window        time.Duration
+	ZoneIDs       bool
+}
+
+type exporterKey struct {
+	Region string
+	// AvailabilityZone is the zone id when exporting by zone id
+	AvailabilityZone string
+	InstanceType     string
+	Product          string
+}
+
+// priceExporter periodically fetches the latest spot prices and serves them in the
+// prometheus text exposition format
+type priceExporter struct {
+	params exporterParams
+
+	sync.Mutex
+	prices      map[exporterKey]data.SpotPrice
+	fetchErrors map[string]int
+}
+
+func newPriceExporter(params exporterParams) *priceExporter {
+	e := &priceExporter{
+		params:      params,
+		prices:      map[exporterKey]data.SpotPrice{},
+		fetchErrors: map[string]int{},
+	}
+
+	// export the error counters from the start so rate() sees the first failure
+	for _, region := range params.Regions {
+		e.fetchErrors[region] = 0
+	}
+
+	return e
+}
+
+// Run fetches prices immediately and then every interval until ctx is done
+func (e *priceExporter) Run(ctx context.Context, interval time.Duration) {
+	for {
+		e.Update(ctx)
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Update fetches the latest prices for every region and product, counting errors per region
+func (e *priceExporter) Update(ctx context.Context) {
+	tr := timerange.Range{time.Now().Add(-e.params.Window), time.Now()}
+
+	for _, region := range e.params.Regions {
+		var ids map[string]string
+		if e.params.ZoneIDs {
+			var err error
+			if ids, err = zoneIDs([]string{region}, fetcher.AvailabilityZones); err != nil {
+				log.Printf("Failed to fetch zone ids for %s: %v", region, err)
+				e.Lock()
+				e.fetchErrors[region]++
+				e.Unlock()
+				continue
+			}
+		}
+
+		results, g := fetcher.BatchFetch(ctx, e.params.Concurrency, fetcher.BatchFetchSpec{
+			InstanceTypes: e.params.InstanceTypes,
+			Regions:       []string{region},
+			Products:      e.params.Products,
+			Range:         tr,
+		})
+
+		latest := map[exporterKey]data.SpotPrice{}
+		for price := range results {
+			az := price.AvailabilityZone
+			if id, ok := ids[az]; ok {
+				az = id
+			}
+
+			key := exporterKey{price.Region, az, price.InstanceType, price.ProductDescription}
+			if existing, ok := latest[key]; !ok || price.Timestamp.After(existing.Timestamp) {
+				latest[key] = price
+			}
+		}
+
+		e.Lock()
+		if err := g.Wait(); err != nil {
+			log.Printf("Failed to fetch prices for %s: %v", region, err)
+			e.fetchErrors[region]++
+		} else {
+			e.replaceRegion(region, latest)
+		}
+		e.Unlock()
+	}
+}
+
+// replaceRegion replaces the prices for a region, dropping pools that no longer report a
+// price within the window. The lock must be held.
+func (e *priceExporter) replaceRegion(region string, latest map[exporterKey]data.SpotPrice) {
+	for key := range e.prices {
+		if key.Region == region {
+			delete(e.prices, key)
+		}
+	}
+	for key, price := range latest {
+		e.prices[key] = price
+	}
+}
+
+// labelEscaper escapes label values as the prometheus text format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelValue(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func (e *priceExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	e.WriteMetrics(w)
+}
+
+// WriteMetrics writes the current metrics in the prometheus text format
+func (e *priceExporter) WriteMetrics(w io.Writer) {
+	e.Lock()
+	defer e.Unlock()
+
+	keys := []exporterKey{}
+	for key := range e.prices {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	onDemand := func(key exporterKey) float64 {
+		info, _ := data.GetInstanceTypeInfo(key.Region, key.InstanceType, key.Product)
+		return info.Price
+	}
+
+	zoneLabel := "availability_zone"
+	if e.params.ZoneIDs {
+		zoneLabel = "availability_zone_id"
+	}
+
+	writeGauge := func(name, help string, value func(exporterKey) (float64, bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, key := range keys {
+			if v, ok := value(key); ok {
+				fmt.Fprintf(w, "%s{region=%s,%s=%s,instance_type=%s,product=%s} %g\n",
+					name, labelValue(key.Region), zoneLabel, labelValue(key.AvailabilityZone),
+					labelValue(key.InstanceType), labelValue(key.Product), v)
+			}
+		}
+	}
+
+	writeGauge("ec2spot_spot_price", "Current spot price in USD per hour", func(key exporterKey) (float64, bool) {
+		return e.prices[key].Price, true
+	})
+
+	writeGauge("ec2spot_on_demand_price", "On-demand price in USD per hour", func(key exporterKey) (float64, bool) {
+		v := onDemand(key)
+		return v, v > 0
+	})
+
+	writeGauge("ec2spot_spot_price_ratio", "Current spot price as a ratio of the on-demand price", func(key exporterKey) (float64, bool) {
+		v := onDemand(key)
+		if v <= 0 {
+			return 0, false
+		}
+		return e.prices[key].Price / v, true
+	})
+
+	regions := []string{}
+	for region := range e.fetchErrors {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	fmt.Fprintf(w, "# HELP ec2spot_fetch_errors_total Failed attempts to fetch spot prices\n")
+	fmt.Fprintf(w, "# TYPE ec2spot_fetch_errors_total counter\n")
+	for _, region := range regions {
+		fmt.Fprintf(w, "ec2spot_fetch_errors_total{region=%s} %d\n", labelValue(region), e.fetchErrors[region])
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+)
+
+func TestPriceExporterWriteMetrics(t *testing.T) {
+	exp := newPriceExporter(exporterParams{})
+	key := exporterKey{"us-east-1", "us-east-1a", "c4.large", "Linux/UNIX"}
+	exp.prices[key] = data.SpotPrice{Price: 0.025, Timestamp: time.Now()}
+	exp.fetchErrors["eu-west-1"] = 2
+
+	buf := &bytes.Buffer{}
+	exp.WriteMetrics(buf)
+
+	for _, expected := range []string{
+		"# TYPE ec2spot_spot_price gauge\n",
+		`ec2spot_spot_price{region="us-east-1",availability_zone="us-east-1a",instance_type="c4.large",product="Linux/UNIX"} 0.025`,
+		`ec2spot_fetch_errors_total{region="eu-west-1"} 2`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("Expected metrics to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestPriceExporterEscapesLabelValues(t *testing.T) {
+	exp := newPriceExporter(exporterParams{Regions: []string{"us-east-1"}})
+	key := exporterKey{"us-east-1", "us-east-1a", "c4.large", "Linux/UNIX \"é\"\\\n"}
+	exp.prices[key] = data.SpotPrice{Price: 0.025}
+
+	buf := &bytes.Buffer{}
+	exp.WriteMetrics(buf)
+
+	for _, expected := range []string{
+		`product="Linux/UNIX \"é\"\\\n"} 0.025`,
+		`ec2spot_fetch_errors_total{region="us-east-1"} 0`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("Expected metrics to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
+
+func TestPriceExporterDropsPoolsThatStopReporting(t *testing.T) {
+	exp := newPriceExporter(exporterParams{})
+	stale := exporterKey{"us-east-1", "us-east-1a", "c4.large", "Linux/UNIX"}
+	current := exporterKey{"us-east-1", "us-east-1b", "c4.large", "Linux/UNIX"}
+	other := exporterKey{"eu-west-1", "eu-west-1a", "c4.large", "Linux/UNIX"}
+
+	exp.prices[stale] = data.SpotPrice{Price: 0.02}
+	exp.prices[other] = data.SpotPrice{Price: 0.03}
+
+	exp.replaceRegion("us-east-1", map[exporterKey]data.SpotPrice{
+		current: {Price: 0.04},
+	})
+
+	if _, ok := exp.prices[stale]; ok {
+		t.Fatalf("Expected %v to be dropped", stale)
+	}
+	if len(exp.prices) != 2 || exp.prices[current].Price != 0.04 || exp.prices[other].Price != 0.03 {
+		t.Fatalf("Expected the current pool and other regions to remain, got %v", exp.prices)
+	}
+}
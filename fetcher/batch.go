@@ -2,7 +2,9 @@ package fetcher
 
 import (
 	"context"
-	"log"
+	"crypto/sha1"
+	"fmt"
+	"strings"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -11,64 +13,289 @@ import (
 	"github.com/lox/ec2spot/timerange"
 )
 
-const chunkSize = time.Hour * 8
+const (
+	// minChunkSize is the smallest a chunk will be split to
+	minChunkSize = time.Hour
+	// maxPagesPerChunk is how many pages a chunk can return before it's split in two
+	maxPagesPerChunk = 5
+	// maxInstanceTypesPerSpec is the most instance types requested at once
+	maxInstanceTypesPerSpec = 20
+	timeFormat              = "2006-01-02 15:04"
+)
 
 type BatchFetchSpec struct {
-	InstanceTypes     []string
-	Regions           []string
+	InstanceTypes []string
+	Regions       []string
+	// AvailabilityZones are full zone names, each only applies to the region it's in
 	AvailabilityZones []string
-	Product           string
+	Products          []string
 	Days              int
+	// Range overrides Days when set
+	Range timerange.Range
+	// Progress is updated as specs are fetched when set
+	Progress *Progress
+	// Failures collects specs that fail when set, rather than the first failure stopping the batch
+	Failures *Failures
 }
 
-func (params BatchFetchSpec) ToFetchSpecs(chunkSize time.Duration) []FetchSpec {
-	var specs = []FetchSpec{}
-	var forEachAz = func(azs []string, f func(string)) {
-		if len(azs) == 0 {
-			f("")
-			return
-		}
-		for _, az := range azs {
-			f(az)
+// TimeRange returns the range of time to fetch
+func (params BatchFetchSpec) TimeRange() timerange.Range {
+	if !params.Range[0].IsZero() {
+		return params.Range
+	}
+	return timerange.DaysAgo(time.Now(), params.Days)
+}
+
+// zonesIn returns the requested availability zones in a region, or a single empty zone
+// meaning every zone when none were requested
+func (params BatchFetchSpec) zonesIn(region string) []string {
+	if len(params.AvailabilityZones) == 0 {
+		return []string{""}
+	}
+
+	azs := []string{}
+	for _, az := range params.AvailabilityZones {
+		if data.RegionFromAvailabilityZone(az) == region {
+			azs = append(azs, az)
 		}
 	}
+	return azs
+}
 
+// regionZones returns how many region and zone combinations are requested
+func (params BatchFetchSpec) regionZones() int {
+	n := 0
 	for _, region := range params.Regions {
-		for _, instanceType := range params.InstanceTypes {
-			forEachAz(params.AvailabilityZones, func(az string) {
-				for _, r := range timerange.DaysAgo(time.Now(), params.Days).Split(chunkSize) {
+		n += len(params.zonesIn(region))
+	}
+	return n
+}
+
+// ToFetchSpecs splits the batch into specs of chunkSize, each requesting up to typesPerSpec
+// instance types at once
+func (params BatchFetchSpec) ToFetchSpecs(chunkSize time.Duration, typesPerSpec int) []FetchSpec {
+	var specs = []FetchSpec{}
+
+	tr := params.TimeRange()
+
+	for _, region := range params.Regions {
+		for _, instanceTypes := range groupStrings(params.InstanceTypes, typesPerSpec) {
+			for _, az := range params.zonesIn(region) {
+				for _, r := range tr.Split(chunkSize) {
 					specs = append(specs, FetchSpec{
-						Region:             region,
-						Start:              r[0],
-						End:                r[1],
-						ProductDescription: params.Product,
-						InstanceType:       instanceType,
-						AvailabilityZone:   az,
+						Region:              region,
+						Start:               r[0],
+						End:                 r[1],
+						ProductDescriptions: params.Products,
+						InstanceTypes:       instanceTypes,
+						AvailabilityZone:    az,
+						MaxPages:            maxPagesPerChunk,
 					})
 				}
-			})
+			}
 		}
 	}
 
-	log.Printf("Split into %d specs for parallel fetching", len(specs))
-
 	return specs
 }
 
+// groupStrings splits values into groups of at most size
+func groupStrings(values []string, size int) [][]string {
+	if size < 1 {
+		size = 1
+	}
+
+	groups := [][]string{}
+	for len(values) > size {
+		groups = append(groups, values[:size:size])
+		values = values[size:]
+	}
+
+	if len(values) > 0 {
+		groups = append(groups, values)
+	}
+
+	return groups
+}
+
+// typesPerSpec combines instance types into the same request when there are more
+// combinations than workers, so that fewer requests are made without leaving workers idle.
+// Responses include the instance type, so the results fan back out naturally.
+func (params BatchFetchSpec) typesPerSpec(concurrency int) int {
+	others := params.regionZones()
+
+	if others == 0 || others*len(params.InstanceTypes) <= concurrency {
+		return 1
+	}
+
+	// how many groups each region and zone needs to keep every worker busy
+	groups := (concurrency + others - 1) / others
+	size := (len(params.InstanceTypes) + groups - 1) / groups
+
+	if size > maxInstanceTypesPerSpec {
+		size = maxInstanceTypesPerSpec
+	}
+
+	return size
+}
+
+// combinations returns how many specs are needed to cover the range once
+func (params BatchFetchSpec) combinations(typesPerSpec int) int {
+	return params.regionZones() * len(groupStrings(params.InstanceTypes, typesPerSpec))
+}
+
+// initialChunkSize starts with the largest chunks that still give every worker something
+// to do, so quiet combinations are fetched in as few requests as possible. Busy chunks
+// are split as they are fetched.
+func (params BatchFetchSpec) initialChunkSize(concurrency, typesPerSpec int) time.Duration {
+	tr := params.TimeRange()
+	total := tr[1].Sub(tr[0])
+
+	chunks := 1
+	if combinations := params.combinations(typesPerSpec); combinations > 0 && combinations < concurrency {
+		chunks = (concurrency + combinations - 1) / combinations
+	}
+
+	size := total / time.Duration(chunks)
+	if size < minChunkSize {
+		size = minChunkSize
+	}
+
+	// round up so the range isn't left with a sliver of a chunk at the end
+	if size*time.Duration(chunks) < total {
+		size += time.Second
+	}
+
+	return size
+}
+
+// layout returns the chunk size and instance types per spec for the batch. Replays reuse
+// the recorded layout, so the same specs are requested whatever the concurrency.
+func (params BatchFetchSpec) layout(concurrency int) (batchLayout, error) {
+	key := params.layoutKey()
+	if l, ok := replayedLayout(key); ok {
+		return l, nil
+	}
+
+	typesPerSpec := params.typesPerSpec(concurrency)
+	l := batchLayout{
+		ChunkSize:    params.initialChunkSize(concurrency, typesPerSpec),
+		TypesPerSpec: typesPerSpec,
+	}
+
+	if recording() {
+		if err := recordLayout(key, l); err != nil {
+			return l, err
+		}
+	}
+
+	return l, nil
+}
+
+// layoutKey identifies a batch independently of how it's split
+func (params BatchFetchSpec) layoutKey() string {
+	tr := params.TimeRange()
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%s",
+		strings.Join(params.Regions, ","), strings.Join(params.InstanceTypes, ","),
+		strings.Join(params.AvailabilityZones, ","), strings.Join(params.Products, ","),
+		tr[0].UTC().Format(time.RFC3339Nano), tr[1].UTC().Format(time.RFC3339Nano))
+	return fmt.Sprintf("%x", sha1.Sum([]byte(key)))
+}
+
+// splitSpec handles a spec that returned too many pages. Prices come back newest first, so
+// the fetched prices are kept and only the time before the oldest of them is requested
+// again, halved, or without a page limit once it can't be split any further. Prices at
+// the oldest timestamp may be cut off by the page limit, so they are requested again too.
+func splitSpec(spec FetchSpec, fetched data.SpotPriceSlice) (data.SpotPriceSlice, []FetchSpec) {
+	kept := data.SpotPriceSlice{}
+
+	if oldest, ok := oldestIfNewestFirst(fetched); ok && oldest.After(spec.Start) && oldest.Before(spec.End) {
+		for _, p := range fetched {
+			if p.Timestamp.After(oldest) {
+				kept = append(kept, p)
+			}
+		}
+		spec.End = oldest
+	}
+
+	duration := spec.End.Sub(spec.Start)
+	if duration/2 < minChunkSize {
+		spec.MaxPages = 0
+		return kept, []FetchSpec{spec}
+	}
+
+	mid := spec.Start.Add(duration / 2)
+	first, second := spec, spec
+	first.End = mid
+	second.Start = mid
+
+	return kept, []FetchSpec{first, second}
+}
+
+// oldestIfNewestFirst returns the oldest timestamp if prices are ordered newest first
+func oldestIfNewestFirst(prices data.SpotPriceSlice) (time.Time, bool) {
+	if len(prices) == 0 {
+		return time.Time{}, false
+	}
+
+	for i := 1; i < len(prices); i++ {
+		if prices[i].Timestamp.After(prices[i-1].Timestamp) {
+			return time.Time{}, false
+		}
+	}
+
+	return prices[len(prices)-1].Timestamp, true
+}
+
+// fetchResult is sent back to the dispatcher when a worker finishes a spec
+type fetchResult struct {
+	split []FetchSpec
+}
+
 func BatchFetch(ctx context.Context, concurrency int, spec BatchFetchSpec) (chan data.SpotPrice, *errgroup.Group) {
 	g, ctx := errgroup.WithContext(ctx)
-	specs := make(chan FetchSpec, 100)
+	specs := make(chan FetchSpec)
+	done := make(chan fetchResult)
+
+	progress := spec.Progress
+	if progress == nil {
+		progress = NewProgress()
+	}
 
-	// load up a channel with specs to fetch
+	failures := spec.Failures
+
+	// dispatch specs to workers, queueing any that workers split until all are fetched
 	g.Go(func() error {
 		defer close(specs)
-		for _, spec := range spec.ToFetchSpecs(chunkSize) {
+
+		layout, err := spec.layout(concurrency)
+		if err != nil {
+			return err
+		}
+
+		queue := spec.ToFetchSpecs(layout.ChunkSize, layout.TypesPerSpec)
+		progress.start(len(queue))
+		outstanding := 0
+
+		for len(queue) > 0 || outstanding > 0 {
+			var out chan FetchSpec
+			var next FetchSpec
+			if len(queue) > 0 {
+				out, next = specs, queue[0]
+			}
+
 			select {
-			case specs <- spec:
+			case out <- next:
+				queue = queue[1:]
+				outstanding++
+			case result := <-done:
+				outstanding--
+				queue = append(queue, result.split...)
 			case <-ctx.Done():
 				return ctx.Err()
 			}
 		}
+
 		return nil
 	})
 
@@ -77,10 +304,21 @@ func BatchFetch(ctx context.Context, concurrency int, spec BatchFetchSpec) (chan
 	for i := 0; i < concurrency; i++ {
 		g.Go(func() error {
 			for spec := range specs {
+				var split []FetchSpec
+
 				result, err := Fetch(spec)
-				if err != nil {
-					return err
+				if err == ErrTooManyPages {
+					result, split = splitSpec(spec, result)
+					progress.split(len(split)-1, len(result))
+				} else {
+					progress.complete(spec, len(result), err)
+					if err != nil && failures != nil {
+						failures.add(spec, err)
+					} else if err != nil {
+						return err
+					}
 				}
+
 				for _, price := range result {
 					select {
 					case prices <- price:
@@ -88,6 +326,12 @@ func BatchFetch(ctx context.Context, concurrency int, spec BatchFetchSpec) (chan
 						return ctx.Err()
 					}
 				}
+
+				select {
+				case done <- fetchResult{split: split}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
 			return nil
 		})
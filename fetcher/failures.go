@@ -0,0 +1,54 @@
+package fetcher
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FetchError is a FetchSpec that failed to fetch
+type FetchError struct {
+	Spec FetchSpec
+	Err  error
+}
+
+func (e FetchError) Error() string {
+	az := e.Spec.AvailabilityZone
+	if az == "" {
+		az = "all zones"
+	}
+	return fmt.Sprintf("%s %s (%s) %s - %s: %v",
+		e.Spec.Region, strings.Join(e.Spec.InstanceTypes, ","), az,
+		e.Spec.Start.Format(timeFormat), e.Spec.End.Format(timeFormat), e.Err)
+}
+
+// Failures collects specs that failed during a BatchFetch. It's safe for concurrent use.
+type Failures struct {
+	sync.Mutex
+	errors []FetchError
+}
+
+// NewFailures returns an empty Failures
+func NewFailures() *Failures {
+	return &Failures{}
+}
+
+func (f *Failures) add(spec FetchSpec, err error) {
+	f.Lock()
+	defer f.Unlock()
+	f.errors = append(f.errors, FetchError{Spec: spec, Err: err})
+}
+
+// List returns the failed specs
+func (f *Failures) List() []FetchError {
+	f.Lock()
+	defer f.Unlock()
+	return append([]FetchError{}, f.errors...)
+}
+
+// Len returns how many specs failed
+func (f *Failures) Len() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.errors)
+}
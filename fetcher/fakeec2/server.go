@@ -0,0 +1,380 @@
+// Package fakeec2 provides a fake EC2 endpoint that serves DescribeSpotPriceHistory,
+// DescribeAvailabilityZones and DescribeRegions over the EC2 Query protocol, for testing
+// without talking to AWS
+package fakeec2
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	xmlns           = "http://ec2.amazonaws.com/doc/2016-11-15/"
+	timeFormat      = "2006-01-02T15:04:05Z"
+	defaultPageSize = 1000
+)
+
+// Record is a single spot price served by the fake endpoint
+type Record struct {
+	AvailabilityZone   string
+	InstanceType       string
+	ProductDescription string
+	Price              float64
+	Timestamp          time.Time
+}
+
+// Zone is an availability zone served by the fake endpoint
+type Zone struct {
+	Name   string
+	ID     string
+	Region string
+}
+
+// Error is an EC2 API error returned by the fake endpoint
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Server is a fake EC2 endpoint backed by a slice of Records
+type Server struct {
+	*httptest.Server
+
+	sync.Mutex
+	records  []Record
+	zones    []Zone
+	regions  []string
+	pageSize int
+	err      *Error
+	requests []Request
+}
+
+// Request is a DescribeSpotPriceHistory request received by the fake endpoint
+type Request struct {
+	InstanceTypes       []string
+	ProductDescriptions []string
+	AvailabilityZone    string
+	Filters             map[string][]string
+	StartTime, EndTime  time.Time
+	NextToken           string
+}
+
+// NewServer starts a fake EC2 endpoint serving the given records
+func NewServer(records []Record) *Server {
+	s := &Server{records: records, pageSize: defaultPageSize}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// SetPageSize sets how many records are returned per page when MaxResults isn't provided
+func (s *Server) SetPageSize(n int) {
+	s.Lock()
+	defer s.Unlock()
+	s.pageSize = n
+}
+
+// SetZones sets the availability zones returned by DescribeAvailabilityZones
+func (s *Server) SetZones(zones []Zone) {
+	s.Lock()
+	defer s.Unlock()
+	s.zones = zones
+}
+
+// SetRegions sets the regions returned by DescribeRegions
+func (s *Server) SetRegions(regions []string) {
+	s.Lock()
+	defer s.Unlock()
+	s.regions = regions
+}
+
+// SetError causes every subsequent request to fail with err, or succeed if err is nil
+func (s *Server) SetError(err *Error) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = err
+}
+
+// Requests returns the requests that have been received
+func (s *Server) Requests() []Request {
+	s.Lock()
+	defer s.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, Error{Status: http.StatusBadRequest, Code: "MalformedQueryString", Message: err.Error()})
+		return
+	}
+
+	switch action := r.Form.Get("Action"); action {
+	case "DescribeSpotPriceHistory":
+		s.handleSpotPriceHistory(w, r)
+	case "DescribeAvailabilityZones":
+		s.handleAvailabilityZones(w, r)
+	case "DescribeRegions":
+		s.handleRegions(w, r)
+	default:
+		s.writeError(w, Error{Status: http.StatusBadRequest, Code: "InvalidAction", Message: fmt.Sprintf("The action %s is not valid for this web service.", action)})
+	}
+}
+
+func (s *Server) handleAvailabilityZones(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	apiErr, zones := s.err, s.zones
+	s.Unlock()
+
+	if apiErr != nil {
+		s.writeError(w, *apiErr)
+		return
+	}
+
+	resp := describeAvailabilityZonesResponse{Xmlns: xmlns, RequestID: "zones"}
+	for _, z := range zones {
+		resp.Items = append(resp.Items, zoneItem{
+			ZoneName:   z.Name,
+			ZoneID:     z.ID,
+			RegionName: z.Region,
+			ZoneState:  "available",
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleRegions(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	apiErr, regions := s.err, s.regions
+	s.Unlock()
+
+	if apiErr != nil {
+		s.writeError(w, *apiErr)
+		return
+	}
+
+	resp := describeRegionsResponse{Xmlns: xmlns, RequestID: "regions"}
+	for _, region := range regions {
+		resp.Items = append(resp.Items, regionItem{
+			RegionName:     region,
+			RegionEndpoint: fmt.Sprintf("ec2.%s.amazonaws.com", region),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handleSpotPriceHistory(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRequest(r)
+	if err != nil {
+		s.writeError(w, Error{Status: http.StatusBadRequest, Code: "InvalidParameterValue", Message: err.Error()})
+		return
+	}
+
+	s.Lock()
+	s.requests = append(s.requests, req)
+	apiErr, pageSize, records := s.err, s.pageSize, s.records
+	s.Unlock()
+
+	if apiErr != nil {
+		s.writeError(w, *apiErr)
+		return
+	}
+
+	if maxResults := r.Form.Get("MaxResults"); maxResults != "" {
+		if pageSize, err = strconv.Atoi(maxResults); err != nil {
+			s.writeError(w, Error{Status: http.StatusBadRequest, Code: "InvalidParameterValue", Message: "Invalid value for MaxResults"})
+			return
+		}
+	}
+
+	matched := []Record{}
+	for _, rec := range records {
+		if req.matches(rec) {
+			matched = append(matched, rec)
+		}
+	}
+
+	// newest first, like the real api
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Timestamp.After(matched[j].Timestamp)
+	})
+
+	offset := 0
+	if req.NextToken != "" {
+		if offset, err = strconv.Atoi(req.NextToken); err != nil || offset < 0 || offset > len(matched) {
+			s.writeError(w, Error{Status: http.StatusBadRequest, Code: "InvalidNextToken", Message: "The specified token is invalid"})
+			return
+		}
+	}
+
+	end := offset + pageSize
+	resp := describeSpotPriceHistoryResponse{Xmlns: xmlns, RequestID: strconv.Itoa(len(s.Requests()))}
+	if end < len(matched) {
+		resp.NextToken = strconv.Itoa(end)
+	} else {
+		end = len(matched)
+	}
+
+	for _, rec := range matched[offset:end] {
+		resp.Items = append(resp.Items, item{
+			AvailabilityZone:   rec.AvailabilityZone,
+			InstanceType:       rec.InstanceType,
+			ProductDescription: rec.ProductDescription,
+			SpotPrice:          strconv.FormatFloat(rec.Price, 'f', 6, 64),
+			Timestamp:          rec.Timestamp.UTC().Format(timeFormat),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	xml.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, e Error) {
+	w.Header().Set("Content-Type", "text/xml;charset=UTF-8")
+	w.WriteHeader(e.Status)
+	xml.NewEncoder(w).Encode(errorResponse{
+		Errors:    []apiError{{Code: e.Code, Message: e.Message}},
+		RequestID: "error",
+	})
+}
+
+func parseRequest(r *http.Request) (Request, error) {
+	req := Request{
+		InstanceTypes:       listParam(r, "InstanceType"),
+		ProductDescriptions: listParam(r, "ProductDescription"),
+		AvailabilityZone:    r.Form.Get("AvailabilityZone"),
+		NextToken:           r.Form.Get("NextToken"),
+		Filters:             map[string][]string{},
+	}
+
+	for i := 1; r.Form.Get(fmt.Sprintf("Filter.%d.Name", i)) != ""; i++ {
+		name := r.Form.Get(fmt.Sprintf("Filter.%d.Name", i))
+		req.Filters[name] = listParam(r, fmt.Sprintf("Filter.%d.Value", i))
+	}
+
+	var err error
+	if v := r.Form.Get("StartTime"); v != "" {
+		if req.StartTime, err = time.Parse(timeFormat, v); err != nil {
+			return req, fmt.Errorf("Invalid value for StartTime: %s", v)
+		}
+	}
+
+	if v := r.Form.Get("EndTime"); v != "" {
+		if req.EndTime, err = time.Parse(timeFormat, v); err != nil {
+			return req, fmt.Errorf("Invalid value for EndTime: %s", v)
+		}
+	}
+
+	return req, nil
+}
+
+// listParam returns the values of an EC2 Query list parameter, e.g InstanceType.1, InstanceType.2
+func listParam(r *http.Request, prefix string) []string {
+	values := []string{}
+	for i := 1; ; i++ {
+		v, ok := r.Form[fmt.Sprintf("%s.%d", prefix, i)]
+		if !ok {
+			return values
+		}
+		values = append(values, v...)
+	}
+}
+
+func (req Request) matches(rec Record) bool {
+	if !contains(req.InstanceTypes, rec.InstanceType) ||
+		!contains(req.ProductDescriptions, rec.ProductDescription) ||
+		!contains(req.Filters["instance-type"], rec.InstanceType) ||
+		!contains(req.Filters["product-description"], rec.ProductDescription) ||
+		!contains(req.Filters["availability-zone"], rec.AvailabilityZone) {
+		return false
+	}
+
+	if req.AvailabilityZone != "" && req.AvailabilityZone != rec.AvailabilityZone {
+		return false
+	}
+
+	if !req.StartTime.IsZero() && rec.Timestamp.Before(req.StartTime) {
+		return false
+	}
+
+	if !req.EndTime.IsZero() && rec.Timestamp.After(req.EndTime) {
+		return false
+	}
+
+	return true
+}
+
+// contains returns true if values is empty or contains v
+func contains(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+type describeSpotPriceHistoryResponse struct {
+	XMLName   xml.Name `xml:"DescribeSpotPriceHistoryResponse"`
+	Xmlns     string   `xml:"xmlns,attr"`
+	RequestID string   `xml:"requestId"`
+	Items     []item   `xml:"spotPriceHistorySet>item"`
+	NextToken string   `xml:"nextToken,omitempty"`
+}
+
+type item struct {
+	AvailabilityZone   string `xml:"availabilityZone"`
+	InstanceType       string `xml:"instanceType"`
+	ProductDescription string `xml:"productDescription"`
+	SpotPrice          string `xml:"spotPrice"`
+	Timestamp          string `xml:"timestamp"`
+}
+
+type describeAvailabilityZonesResponse struct {
+	XMLName   xml.Name   `xml:"DescribeAvailabilityZonesResponse"`
+	Xmlns     string     `xml:"xmlns,attr"`
+	RequestID string     `xml:"requestId"`
+	Items     []zoneItem `xml:"availabilityZoneInfo>item"`
+}
+
+type zoneItem struct {
+	ZoneName   string `xml:"zoneName"`
+	ZoneID     string `xml:"zoneId"`
+	RegionName string `xml:"regionName"`
+	ZoneState  string `xml:"zoneState"`
+}
+
+type describeRegionsResponse struct {
+	XMLName   xml.Name     `xml:"DescribeRegionsResponse"`
+	Xmlns     string       `xml:"xmlns,attr"`
+	RequestID string       `xml:"requestId"`
+	Items     []regionItem `xml:"regionInfo>item"`
+}
+
+type regionItem struct {
+	RegionName     string `xml:"regionName"`
+	RegionEndpoint string `xml:"regionEndpoint"`
+}
+
+type errorResponse struct {
+	XMLName   xml.Name   `xml:"Response"`
+	Errors    []apiError `xml:"Errors>Error"`
+	RequestID string     `xml:"RequestID"`
+}
+
+type apiError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
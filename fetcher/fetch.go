@@ -1,42 +1,98 @@
 package fetcher
 
 import (
+	"errors"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/lox/ec2spot/data"
 )
 
 var (
-	clients     = map[string]*ec2.EC2{}
-	clientsLock sync.Mutex
+	clients      = map[string]*ec2.EC2{}
+	clientsLock  sync.Mutex
+	clientConfig ClientConfig
 )
 
+// ClientConfig is applied to every per-region EC2 client
+type ClientConfig struct {
+	// EndpointURL overrides the EC2 endpoint, e.g for a VPC endpoint or local stand-in
+	EndpointURL string
+	// Profile is a named profile from the shared credentials and config files
+	Profile string
+	// RoleARN is a role to assume for all requests
+	RoleARN string
+	// ExternalID is passed when assuming RoleARN
+	ExternalID string
+}
+
+// Configure sets the config used for EC2 clients, discarding any cached clients, zones and regions
+func Configure(c ClientConfig) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	clientConfig = c
+	clients = map[string]*ec2.EC2{}
+
+	zonesLock.Lock()
+	zones = map[string][]Zone{}
+	zonesLock.Unlock()
+
+	regionsLock.Lock()
+	regions = nil
+	regionsLock.Unlock()
+}
+
 type FetchSpec struct {
-	Region             string
-	Start, End         time.Time
-	InstanceType       string
-	ProductDescription string
-	AvailabilityZone   string
+	Region              string
+	Start, End          time.Time
+	InstanceTypes       []string
+	ProductDescriptions []string
+	AvailabilityZone    string
+	// MaxPages stops the fetch with ErrTooManyPages if more pages are needed, zero is unlimited
+	MaxPages int
 }
 
+// ErrTooManyPages is returned by Fetch when a spec needs more than its MaxPages, along with
+// the prices from the pages fetched before it stopped
+var ErrTooManyPages = errors.New("too many pages")
+
 func ec2Client(region string) (*ec2.EC2, error) {
 	clientsLock.Lock()
 	defer clientsLock.Unlock()
 
 	svc, ok := clients[region]
 	if !ok {
-		config := aws.NewConfig()
-		sess, err := session.NewSession(config.WithRegion(region))
+		sess, err := session.NewSessionWithOptions(session.Options{
+			Config:            *aws.NewConfig().WithRegion(region),
+			Profile:           clientConfig.Profile,
+			SharedConfigState: session.SharedConfigEnable,
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		svc = ec2.New(sess)
+		// the endpoint only applies to ec2, assuming a role still talks to sts
+		config := aws.NewConfig()
+		if clientConfig.EndpointURL != "" {
+			config = config.WithEndpoint(clientConfig.EndpointURL)
+		}
+
+		if clientConfig.RoleARN != "" {
+			config = config.WithCredentials(stscreds.NewCredentials(sess, clientConfig.RoleARN,
+				func(p *stscreds.AssumeRoleProvider) {
+					if clientConfig.ExternalID != "" {
+						p.ExternalID = aws.String(clientConfig.ExternalID)
+					}
+				}))
+		}
+
+		svc = ec2.New(sess, config)
 		clients[region] = svc
 	}
 
@@ -44,15 +100,33 @@ func ec2Client(region string) (*ec2.EC2, error) {
 }
 
 func Fetch(spec FetchSpec) (data.SpotPriceSlice, error) {
+	if replaying() {
+		pages, err := replayPages(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		prices := data.SpotPriceSlice{}
+		for _, page := range pages {
+			prices = appendPage(prices, spec, page)
+		}
+
+		if spec.MaxPages > 0 && len(pages) > spec.MaxPages {
+			return prices, ErrTooManyPages
+		}
+		return prices, nil
+	}
+
 	svc, err := ec2Client(spec.Region)
 	if err != nil {
 		return nil, err
 	}
 
 	prices := data.SpotPriceSlice{}
+	pages := []*ec2.DescribeSpotPriceHistoryOutput{}
 	params := &ec2.DescribeSpotPriceHistoryInput{
-		InstanceTypes:       aws.StringSlice([]string{spec.InstanceType}),
-		ProductDescriptions: aws.StringSlice([]string{spec.ProductDescription}),
+		InstanceTypes:       aws.StringSlice(spec.InstanceTypes),
+		ProductDescriptions: aws.StringSlice(spec.ProductDescriptions),
 		StartTime:           aws.Time(spec.Start),
 		EndTime:             aws.Time(spec.End),
 	}
@@ -63,18 +137,39 @@ func Fetch(spec FetchSpec) (data.SpotPriceSlice, error) {
 
 	err = svc.DescribeSpotPriceHistoryPages(params,
 		func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
-			for _, price := range page.SpotPriceHistory {
-				priceVal, _ := strconv.ParseFloat(*price.SpotPrice, 64)
-				prices = append(prices, data.SpotPrice{
-					Region:           spec.Region,
-					InstanceType:     *price.InstanceType,
-					AvailabilityZone: *price.AvailabilityZone,
-					Price:            float64(priceVal),
-					Timestamp:        *price.Timestamp,
-				})
-			}
-			return lastPage
+			prices = appendPage(prices, spec, page)
+			pages = append(pages, page)
+			// fetch one page past the limit to know the limit was exceeded
+			return spec.MaxPages == 0 || len(pages) <= spec.MaxPages
 		})
+	if err != nil {
+		return nil, err
+	}
 
-	return data.SpotPriceSlice(prices), err
+	if recording() {
+		if err := recordPages(spec, pages); err != nil {
+			return nil, err
+		}
+	}
+
+	if spec.MaxPages > 0 && len(pages) > spec.MaxPages {
+		return prices, ErrTooManyPages
+	}
+
+	return data.SpotPriceSlice(prices), nil
+}
+
+func appendPage(prices data.SpotPriceSlice, spec FetchSpec, page *ec2.DescribeSpotPriceHistoryOutput) data.SpotPriceSlice {
+	for _, price := range page.SpotPriceHistory {
+		priceVal, _ := strconv.ParseFloat(*price.SpotPrice, 64)
+		prices = append(prices, data.SpotPrice{
+			Region:             spec.Region,
+			InstanceType:       *price.InstanceType,
+			ProductDescription: aws.StringValue(price.ProductDescription),
+			AvailabilityZone:   *price.AvailabilityZone,
+			Price:              float64(priceVal),
+			Timestamp:          *price.Timestamp,
+		})
+	}
+	return prices
 }
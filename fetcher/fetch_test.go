@@ -0,0 +1,390 @@
+package fetcher_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+	"github.com/lox/ec2spot/fetcher/fakeec2"
+)
+
+const product = "Linux/UNIX (Amazon VPC)"
+
+func newFakeEC2(t *testing.T, records []fakeec2.Record) *fakeec2.Server {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIAFAKE")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "fake")
+
+	srv := fakeec2.NewServer(records)
+	fetcher.Configure(fetcher.ClientConfig{EndpointURL: srv.URL})
+
+	return srv
+}
+
+func hourlyRecords(start time.Time, hours int, azs ...string) []fakeec2.Record {
+	records := []fakeec2.Record{}
+	for _, az := range azs {
+		for i := 0; i < hours; i++ {
+			records = append(records, fakeec2.Record{
+				AvailabilityZone:   az,
+				InstanceType:       "c4.large",
+				ProductDescription: product,
+				Price:              0.1 + float64(i)/1000,
+				Timestamp:          start.Add(time.Duration(i) * time.Hour),
+			})
+		}
+	}
+	return records
+}
+
+func TestFetchFollowsPagination(t *testing.T) {
+	start := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+	srv := newFakeEC2(t, hourlyRecords(start, 24, "us-east-1a"))
+	defer srv.Close()
+	srv.SetPageSize(5)
+
+	prices, err := fetcher.Fetch(fetcher.FetchSpec{
+		Region:              "us-east-1",
+		Start:               start,
+		End:                 start.Add(time.Hour * 24),
+		InstanceTypes:       []string{"c4.large"},
+		ProductDescriptions: []string{product},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(prices); l != 24 {
+		t.Fatalf("Expected 24 prices, got %d", l)
+	}
+
+	if l := len(srv.Requests()); l != 5 {
+		t.Fatalf("Expected 5 requests, got %d", l)
+	}
+}
+
+func TestFetchFiltersByAvailabilityZone(t *testing.T) {
+	start := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+	srv := newFakeEC2(t, hourlyRecords(start, 4, "us-east-1a", "us-east-1b"))
+	defer srv.Close()
+
+	prices, err := fetcher.Fetch(fetcher.FetchSpec{
+		Region:              "us-east-1",
+		Start:               start,
+		End:                 start.Add(time.Hour * 4),
+		InstanceTypes:       []string{"c4.large"},
+		ProductDescriptions: []string{product},
+		AvailabilityZone:    "us-east-1b",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if azs := prices.AvailabilityZones(); len(azs) != 1 || azs[0] != "us-east-1b" {
+		t.Fatalf("Expected only us-east-1b, got %v", azs)
+	}
+
+	if reqs := srv.Requests(); reqs[0].AvailabilityZone != "us-east-1b" {
+		t.Fatalf("Expected request for us-east-1b, got %q", reqs[0].AvailabilityZone)
+	}
+}
+
+func TestFetchReturnsAPIErrors(t *testing.T) {
+	srv := newFakeEC2(t, nil)
+	defer srv.Close()
+	srv.SetError(&fakeec2.Error{
+		Status:  http.StatusForbidden,
+		Code:    "UnauthorizedOperation",
+		Message: "You are not authorized to perform this operation.",
+	})
+
+	_, err := fetcher.Fetch(fetcher.FetchSpec{
+		Region:              "us-east-1",
+		Start:               time.Now().Add(-time.Hour),
+		End:                 time.Now(),
+		InstanceTypes:       []string{"c4.large"},
+		ProductDescriptions: []string{product},
+	})
+
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "UnauthorizedOperation" {
+		t.Fatalf("Expected UnauthorizedOperation, got %v", err)
+	}
+}
+
+func TestBatchFetch(t *testing.T) {
+	start := time.Now().Add(-time.Hour * 24).Truncate(time.Hour)
+	srv := newFakeEC2(t, hourlyRecords(start, 24, "us-east-1a", "us-east-1b", "us-east-1c"))
+	defer srv.Close()
+	srv.SetPageSize(7)
+	progress := fetcher.NewProgress()
+
+	results, g := fetcher.BatchFetch(context.Background(), 3, fetcher.BatchFetchSpec{
+		InstanceTypes:     []string{"c4.large"},
+		Regions:           []string{"us-east-1"},
+		AvailabilityZones: []string{"us-east-1a", "us-east-1c"},
+		Products:          []string{product},
+		Days:              2,
+		Progress:          progress,
+	})
+
+	prices := data.SpotPriceSlice{}
+	for price := range results {
+		prices = append(prices, price)
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(prices.ByAvailabilityZone("us-east-1a")); l != 24 {
+		t.Fatalf("Expected 24 prices for us-east-1a, got %d", l)
+	}
+
+	if l := len(prices.ByAvailabilityZone("us-east-1b")); l != 0 {
+		t.Fatalf("Expected no prices for us-east-1b, got %d", l)
+	}
+
+	if l := len(prices.ByAvailabilityZone("us-east-1c")); l != 24 {
+		t.Fatalf("Expected 24 prices for us-east-1c, got %d", l)
+	}
+
+	if s := progress.Snapshot(); s.Total == 0 || s.Completed != s.Total || s.Records != 48 {
+		t.Fatalf("Expected all specs completed with 48 records, got %+v", s)
+	}
+}
+
+func TestBatchFetchStopsOnError(t *testing.T) {
+	srv := newFakeEC2(t, nil)
+	defer srv.Close()
+	srv.SetError(&fakeec2.Error{
+		Status:  http.StatusBadRequest,
+		Code:    "InvalidParameterValue",
+		Message: "Invalid product",
+	})
+
+	results, g := fetcher.BatchFetch(context.Background(), 2, fetcher.BatchFetchSpec{
+		InstanceTypes: []string{"c4.large"},
+		Regions:       []string{"us-east-1"},
+		Products:      []string{product},
+		Days:          1,
+	})
+
+	for range results {
+	}
+
+	if err := g.Wait(); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestBatchFetchCollectsFailures(t *testing.T) {
+	srv := newFakeEC2(t, nil)
+	defer srv.Close()
+	srv.SetError(&fakeec2.Error{
+		Status:  http.StatusBadRequest,
+		Code:    "InvalidParameterValue",
+		Message: "Invalid product",
+	})
+
+	failures := fetcher.NewFailures()
+	results, g := fetcher.BatchFetch(context.Background(), 2, fetcher.BatchFetchSpec{
+		InstanceTypes: []string{"c4.large"},
+		Regions:       []string{"us-east-1", "us-west-2"},
+		Products:      []string{product},
+		Days:          1,
+		Failures:      failures,
+	})
+
+	for range results {
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	regions := map[string]bool{}
+	for _, f := range failures.List() {
+		regions[f.Spec.Region] = true
+	}
+
+	if !regions["us-east-1"] || !regions["us-west-2"] {
+		t.Fatalf("Expected failures for both regions, got %v", failures.List())
+	}
+}
+
+func TestBatchFetchSplitsBusyChunks(t *testing.T) {
+	start := time.Now().Add(-time.Hour * 100).Truncate(time.Hour)
+	srv := newFakeEC2(t, hourlyRecords(start, 100, "us-east-1a"))
+	defer srv.Close()
+	srv.SetPageSize(2)
+
+	progress := fetcher.NewProgress()
+	results, g := fetcher.BatchFetch(context.Background(), 1, fetcher.BatchFetchSpec{
+		InstanceTypes: []string{"c4.large"},
+		Regions:       []string{"us-east-1"},
+		Products:      []string{product},
+		Days:          5,
+		Progress:      progress,
+	})
+
+	timestamps := map[time.Time]bool{}
+	for price := range results {
+		timestamps[price.Timestamp] = true
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(timestamps); l != 100 {
+		t.Fatalf("Expected 100 prices, got %d", l)
+	}
+
+	if s := progress.Snapshot(); s.Total < 2 || s.Completed != s.Total {
+		t.Fatalf("Expected the chunk to be split and completed, got %+v", s)
+	}
+}
+
+func TestBatchFetchKeepsPagesFromSplitChunks(t *testing.T) {
+	start := time.Now().Add(-time.Hour * 100).Truncate(time.Hour)
+	srv := newFakeEC2(t, hourlyRecords(start, 100, "us-east-1a"))
+	defer srv.Close()
+	srv.SetPageSize(2)
+
+	results, g := fetcher.BatchFetch(context.Background(), 1, fetcher.BatchFetchSpec{
+		InstanceTypes: []string{"c4.large"},
+		Regions:       []string{"us-east-1"},
+		Products:      []string{product},
+		Days:          5,
+	})
+
+	prices := data.SpotPriceSlice{}
+	for price := range results {
+		prices = append(prices, price)
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	newest := start.Add(time.Hour * 99)
+	for _, req := range srv.Requests() {
+		if req.NextToken == "" && req.StartTime.After(start) && !req.EndTime.Before(newest) {
+			t.Fatalf("Expected the newest prices to only be requested once, got a request from %v to %v",
+				req.StartTime, req.EndTime)
+		}
+	}
+
+	if l := len(prices.ByAvailabilityZone("us-east-1a")); l != 100 {
+		t.Fatalf("Expected each of the 100 prices once, got %d", l)
+	}
+}
+
+func TestBatchFetchGroupsInstanceTypes(t *testing.T) {
+	start := time.Now().Add(-time.Hour * 12).Truncate(time.Hour)
+	records := []fakeec2.Record{}
+	for _, instanceType := range []string{"c4.large", "m4.large", "r4.large"} {
+		for _, r := range hourlyRecords(start, 10, "us-east-1a") {
+			r.InstanceType = instanceType
+			records = append(records, r)
+		}
+	}
+
+	srv := newFakeEC2(t, records)
+	defer srv.Close()
+
+	results, g := fetcher.BatchFetch(context.Background(), 1, fetcher.BatchFetchSpec{
+		InstanceTypes: []string{"c4.large", "m4.large", "r4.large"},
+		Regions:       []string{"us-east-1"},
+		Products:      []string{product},
+		Days:          1,
+	})
+
+	prices := data.SpotPriceSlice{}
+	for price := range results {
+		prices = append(prices, price)
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := srv.Requests()
+	if l := len(reqs); l != 1 {
+		t.Fatalf("Expected 1 request, got %d", l)
+	}
+
+	if l := len(reqs[0].InstanceTypes); l != 3 {
+		t.Fatalf("Expected 3 instance types in the request, got %d", l)
+	}
+
+	for _, instanceType := range []string{"c4.large", "m4.large", "r4.large"} {
+		if l := len(prices.ByInstanceType(instanceType)); l != 10 {
+			t.Fatalf("Expected 10 prices for %s, got %d", instanceType, l)
+		}
+	}
+}
+
+func TestAvailabilityZonesIncludeIDs(t *testing.T) {
+	srv := newFakeEC2(t, nil)
+	defer srv.Close()
+	srv.SetZones([]fakeec2.Zone{
+		{Name: "us-east-1b", ID: "use1-az1", Region: "us-east-1"},
+		{Name: "us-east-1a", ID: "use1-az4", Region: "us-east-1"},
+	})
+
+	zones, err := fetcher.AvailabilityZones("us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zones) != 2 || zones[0].Name != "us-east-1a" || zones[0].ID != "use1-az4" {
+		t.Fatalf("Expected zones sorted by name with ids, got %+v", zones)
+	}
+}
+
+func TestBatchFetchAppliesZonesToTheirRegion(t *testing.T) {
+	start := time.Now().Add(-time.Hour * 6).Truncate(time.Hour)
+	srv := newFakeEC2(t, hourlyRecords(start, 6, "us-east-1a"))
+	defer srv.Close()
+
+	results, g := fetcher.BatchFetch(context.Background(), 2, fetcher.BatchFetchSpec{
+		InstanceTypes:     []string{"c4.large"},
+		Regions:           []string{"us-east-1", "eu-west-1"},
+		AvailabilityZones: []string{"us-east-1a", "eu-west-1b"},
+		Products:          []string{product},
+		Days:              1,
+	})
+
+	for range results {
+	}
+
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range srv.Requests() {
+		if req.AvailabilityZone != "us-east-1a" && req.AvailabilityZone != "eu-west-1b" {
+			t.Fatalf("Expected requests only for the selected zones, got %q", req.AvailabilityZone)
+		}
+	}
+}
+
+func TestRegions(t *testing.T) {
+	srv := newFakeEC2(t, nil)
+	defer srv.Close()
+	srv.SetRegions([]string{"us-west-2", "eu-west-1", "us-east-1"})
+
+	regions, err := fetcher.Regions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(regions) != 3 || regions[0] != "eu-west-1" || regions[2] != "us-west-2" {
+		t.Fatalf("Expected sorted regions, got %v", regions)
+	}
+}
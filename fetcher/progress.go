@@ -0,0 +1,96 @@
+package fetcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress tracks how far through a BatchFetch we are. It's safe for concurrent use.
+type Progress struct {
+	sync.Mutex
+	started   time.Time
+	total     int
+	completed int
+	records   int
+	errors    map[string]int
+}
+
+// NewProgress returns an empty Progress
+func NewProgress() *Progress {
+	return &Progress{errors: map[string]int{}}
+}
+
+// ProgressSnapshot is the state of a Progress at a point in time
+type ProgressSnapshot struct {
+	Total     int
+	Completed int
+	Records   int
+	Errors    map[string]int
+	Elapsed   time.Duration
+	ETA       time.Duration
+}
+
+// Percent returns how much of the fetch is done, from 0 to 100
+func (s ProgressSnapshot) Percent() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Completed) / float64(s.Total) * 100
+}
+
+func (p *Progress) start(total int) {
+	p.Lock()
+	defer p.Unlock()
+	if p.started.IsZero() {
+		p.started = time.Now()
+	}
+	p.total += total
+}
+
+// split adds specs created by splitting a spec that returned too many pages, along with
+// the records kept from it
+func (p *Progress) split(added, records int) {
+	p.Lock()
+	defer p.Unlock()
+	p.total += added
+	p.records += records
+}
+
+func (p *Progress) complete(spec FetchSpec, records int, err error) {
+	p.Lock()
+	defer p.Unlock()
+	p.completed++
+	p.records += records
+	if err != nil {
+		p.errors[spec.Region]++
+	}
+}
+
+// Snapshot returns the current progress, estimating the time remaining from the average
+// time taken per completed spec
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.Lock()
+	defer p.Unlock()
+
+	s := ProgressSnapshot{
+		Total:     p.total,
+		Completed: p.completed,
+		Records:   p.records,
+		Errors:    map[string]int{},
+	}
+
+	for region, count := range p.errors {
+		s.Errors[region] = count
+	}
+
+	if !p.started.IsZero() {
+		s.Elapsed = time.Since(p.started)
+	}
+
+	if p.completed > 0 && p.completed < p.total {
+		perSpec := s.Elapsed / time.Duration(p.completed)
+		s.ETA = perSpec * time.Duration(p.total-p.completed)
+	}
+
+	return s
+}
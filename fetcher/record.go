@@ -0,0 +1,267 @@
+package fetcher
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const manifestFile = "manifest.json"
+
+var (
+	recordDir, replayDir           string
+	recordManifest, replayManifest manifest
+	recordLock                     sync.Mutex
+)
+
+// manifest records when a recording was made and how each batch was split, so a replay
+// requests the same specs
+type manifest struct {
+	Time    time.Time                 `json:"time"`
+	Layouts map[string]recordedLayout `json:"layouts,omitempty"`
+}
+
+// batchLayout is how a batch is split into specs
+type batchLayout struct {
+	ChunkSize    time.Duration
+	TypesPerSpec int
+}
+
+// recordedLayout is a batchLayout with a readable chunk size
+type recordedLayout struct {
+	ChunkSize    string `json:"chunk_size"`
+	TypesPerSpec int    `json:"types_per_spec"`
+}
+
+// recordedFetch is a FetchSpec along with the DescribeSpotPriceHistory pages it returned
+type recordedFetch struct {
+	Spec  FetchSpec                             `json:"spec"`
+	Pages []*ec2.DescribeSpotPriceHistoryOutput `json:"pages"`
+}
+
+// Record saves every page fetched from now on into dir, noting t as the time of the analysis.
+// An empty dir stops recording.
+func Record(dir string, t time.Time) error {
+	if dir == "" {
+		recordLock.Lock()
+		defer recordLock.Unlock()
+		recordDir = ""
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	m := manifest{Time: t, Layouts: map[string]recordedLayout{}}
+	if err := writeManifest(dir, m); err != nil {
+		return err
+	}
+
+	recordDir, recordManifest = dir, m
+	return nil
+}
+
+func writeManifest(dir string, m manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, manifestFile), b, 0644)
+}
+
+// recordLayout notes how the batch with key was split
+func recordLayout(key string, l batchLayout) error {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	recordManifest.Layouts[key] = recordedLayout{ChunkSize: l.ChunkSize.String(), TypesPerSpec: l.TypesPerSpec}
+	return writeManifest(recordDir, recordManifest)
+}
+
+// replayedLayout returns how the batch with key was split when it was recorded
+func replayedLayout(key string) (batchLayout, bool) {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+
+	if replayDir == "" {
+		return batchLayout{}, false
+	}
+
+	rec, ok := replayManifest.Layouts[key]
+	if !ok {
+		return batchLayout{}, false
+	}
+
+	chunkSize, err := time.ParseDuration(rec.ChunkSize)
+	if err != nil {
+		return batchLayout{}, false
+	}
+
+	return batchLayout{ChunkSize: chunkSize, TypesPerSpec: rec.TypesPerSpec}, true
+}
+
+// Replay serves fetches from a recording in dir instead of calling AWS, returning the
+// time the recording was made. An empty dir stops replaying.
+func Replay(dir string) (time.Time, error) {
+	if dir == "" {
+		recordLock.Lock()
+		defer recordLock.Unlock()
+		replayDir = ""
+		return time.Time{}, nil
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return time.Time{}, err
+	}
+
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	replayDir, replayManifest = dir, m
+	return m.Time, nil
+}
+
+func recording() bool {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	return recordDir != ""
+}
+
+func replaying() bool {
+	recordLock.Lock()
+	defer recordLock.Unlock()
+	return replayDir != ""
+}
+
+// recordingFile returns a stable filename for a spec
+func recordingFile(dir string, spec FetchSpec) string {
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%d",
+		spec.Region, strings.Join(spec.InstanceTypes, ","), strings.Join(spec.ProductDescriptions, ","), spec.AvailabilityZone,
+		spec.Start.UTC().Format(time.RFC3339Nano), spec.End.UTC().Format(time.RFC3339Nano), spec.MaxPages)
+	return filepath.Join(dir, fmt.Sprintf("%x.json", sha1.Sum([]byte(key))))
+}
+
+func recordPages(spec FetchSpec, pages []*ec2.DescribeSpotPriceHistoryOutput) error {
+	recordLock.Lock()
+	dir := recordDir
+	recordLock.Unlock()
+
+	b, err := json.MarshalIndent(recordedFetch{Spec: spec, Pages: pages}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(recordingFile(dir, spec), b, 0644)
+}
+
+func replayPages(spec FetchSpec) ([]*ec2.DescribeSpotPriceHistoryOutput, error) {
+	recordLock.Lock()
+	dir := replayDir
+	recordLock.Unlock()
+
+	b, err := ioutil.ReadFile(recordingFile(dir, spec))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording for %s %s %s between %s and %s",
+			spec.Region, strings.Join(spec.InstanceTypes, ","), spec.AvailabilityZone, spec.Start, spec.End)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var rec recordedFetch
+	if err := json.Unmarshal(b, &rec); err != nil {
+		return nil, err
+	}
+
+	return rec.Pages, nil
+}
+
+// zonesFile returns the filename availability zones for a region are recorded in
+func zonesFile(dir, region string) string {
+	return filepath.Join(dir, fmt.Sprintf("zones-%s.json", region))
+}
+
+func recordZones(region string, zones []Zone) error {
+	recordLock.Lock()
+	dir := recordDir
+	recordLock.Unlock()
+
+	b, err := json.MarshalIndent(zones, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(zonesFile(dir, region), b, 0644)
+}
+
+func replayZones(region string) ([]Zone, error) {
+	recordLock.Lock()
+	dir := replayDir
+	recordLock.Unlock()
+
+	b, err := ioutil.ReadFile(zonesFile(dir, region))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording of availability zones for %s", region)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var zones []Zone
+	if err := json.Unmarshal(b, &zones); err != nil {
+		return nil, err
+	}
+
+	return zones, nil
+}
+
+const regionsFile = "regions.json"
+
+func recordRegions(regions []string) error {
+	recordLock.Lock()
+	dir := recordDir
+	recordLock.Unlock()
+
+	b, err := json.MarshalIndent(regions, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, regionsFile), b, 0644)
+}
+
+func replayRegions() ([]string, error) {
+	recordLock.Lock()
+	dir := replayDir
+	recordLock.Unlock()
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, regionsFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no recording of regions")
+	} else if err != nil {
+		return nil, err
+	}
+
+	var regions []string
+	if err := json.Unmarshal(b, &regions); err != nil {
+		return nil, err
+	}
+
+	return regions, nil
+}
@@ -0,0 +1,117 @@
+package fetcher_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/fetcher"
+	"github.com/lox/ec2spot/timerange"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ec2spot-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+	srv := newFakeEC2(t, hourlyRecords(start, 12, "us-east-1a"))
+	srv.SetPageSize(5)
+
+	spec := fetcher.FetchSpec{
+		Region:              "us-east-1",
+		Start:               start,
+		End:                 start.Add(time.Hour * 12),
+		InstanceTypes:       []string{"c4.large"},
+		ProductDescriptions: []string{product},
+	}
+
+	if err := fetcher.Record(dir, start); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := fetcher.Fetch(spec)
+	fetcher.Record("", time.Time{})
+	srv.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recordedAt, err := fetcher.Replay(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fetcher.Replay("")
+
+	if !recordedAt.Equal(start) {
+		t.Fatalf("Expected recording time of %v, got %v", start, recordedAt)
+	}
+
+	replayed, err := fetcher.Fetch(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replayed) != 12 || len(replayed) != len(recorded) {
+		t.Fatalf("Expected 12 replayed prices, got %d", len(replayed))
+	}
+
+	spec.AvailabilityZone = "us-east-1b"
+	if _, err := fetcher.Fetch(spec); err == nil {
+		t.Fatal("Expected an error for a spec that wasn't recorded")
+	}
+}
+
+func TestReplayIgnoresConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ec2spot-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+	srv := newFakeEC2(t, hourlyRecords(start, 48, "us-east-1a"))
+
+	batch := fetcher.BatchFetchSpec{
+		InstanceTypes: []string{"c4.large"},
+		Regions:       []string{"us-east-1"},
+		Products:      []string{product},
+		Range:         timerange.Range{start, start.Add(time.Hour * 48)},
+	}
+
+	fetchAll := func(concurrency int) (int, error) {
+		results, g := fetcher.BatchFetch(context.Background(), concurrency, batch)
+		count := 0
+		for range results {
+			count++
+		}
+		return count, g.Wait()
+	}
+
+	if err := fetcher.Record(dir, start); err != nil {
+		t.Fatal(err)
+	}
+	recorded, err := fetchAll(4)
+	fetcher.Record("", time.Time{})
+	srv.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fetcher.Replay(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer fetcher.Replay("")
+
+	replayed, err := fetchAll(1)
+	if err != nil {
+		t.Fatalf("Expected replaying with a different concurrency to work, got %v", err)
+	}
+
+	if replayed != recorded {
+		t.Fatalf("Expected %d replayed prices, got %d", recorded, replayed)
+	}
+}
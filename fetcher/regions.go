@@ -0,0 +1,72 @@
+package fetcher
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// regionsEndpoint is the region asked for the list of regions
+const regionsEndpoint = "us-east-1"
+
+var (
+	regions     []string
+	regionsLock sync.Mutex
+)
+
+// Regions returns the sorted regions enabled for the account. Results are cached.
+func Regions() ([]string, error) {
+	regionsLock.Lock()
+	cached := regions
+	regionsLock.Unlock()
+
+	if cached != nil {
+		return cached, nil
+	}
+
+	var result []string
+	var err error
+
+	if replaying() {
+		result, err = replayRegions()
+	} else {
+		result, err = describeRegions()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if recording() {
+		if err := recordRegions(result); err != nil {
+			return nil, err
+		}
+	}
+
+	regionsLock.Lock()
+	regions = result
+	regionsLock.Unlock()
+
+	return result, nil
+}
+
+func describeRegions() ([]string, error) {
+	svc, err := ec2Client(regionsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := svc.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, r := range output.Regions {
+		result = append(result, aws.StringValue(r.RegionName))
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
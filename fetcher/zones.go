@@ -0,0 +1,104 @@
+package fetcher
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var (
+	zones     = map[string][]Zone{}
+	zonesLock sync.Mutex
+)
+
+// Zone is an availability zone in a region. Names like us-east-1a map to different
+// physical zones in each account, the ID (e.g use1-az4) is the same everywhere.
+type Zone struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	Region string `json:"region"`
+}
+
+// describeAvailabilityZonesOutput replaces ec2.DescribeAvailabilityZonesOutput, which
+// predates zone ids in the vendored sdk
+type describeAvailabilityZonesOutput struct {
+	_ struct{} `type:"structure"`
+
+	AvailabilityZones []*availabilityZone `locationName:"availabilityZoneInfo" locationNameList:"item" type:"list"`
+}
+
+type availabilityZone struct {
+	_ struct{} `type:"structure"`
+
+	RegionName *string `locationName:"regionName" type:"string"`
+	ZoneName   *string `locationName:"zoneName" type:"string"`
+	ZoneId     *string `locationName:"zoneId" type:"string"`
+}
+
+// AvailabilityZones returns the availability zones in a region sorted by name. Results are
+// cached, zones rarely change.
+func AvailabilityZones(region string) ([]Zone, error) {
+	zonesLock.Lock()
+	cached, ok := zones[region]
+	zonesLock.Unlock()
+
+	if ok {
+		return cached, nil
+	}
+
+	var result []Zone
+	var err error
+
+	if replaying() {
+		result, err = replayZones(region)
+	} else {
+		result, err = describeAvailabilityZones(region)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if recording() {
+		if err := recordZones(region, result); err != nil {
+			return nil, err
+		}
+	}
+
+	zonesLock.Lock()
+	zones[region] = result
+	zonesLock.Unlock()
+
+	return result, nil
+}
+
+func describeAvailabilityZones(region string) ([]Zone, error) {
+	svc, err := ec2Client(region)
+	if err != nil {
+		return nil, err
+	}
+
+	var output describeAvailabilityZonesOutput
+
+	req, _ := svc.DescribeAvailabilityZonesRequest(&ec2.DescribeAvailabilityZonesInput{})
+	req.Data = &output
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+
+	result := []Zone{}
+	for _, az := range output.AvailabilityZones {
+		result = append(result, Zone{
+			Name:   aws.StringValue(az.ZoneName),
+			ID:     aws.StringValue(az.ZoneId),
+			Region: region,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
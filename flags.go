@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+)
+
+// clientFlags registers flags that configure how the fetcher talks to AWS, returning a
+// function that applies them once the flags are parsed
+func clientFlags(fs *flag.FlagSet) func() {
+	profileFlag := fs.String("profile", "", "Use a named profile from the shared AWS config")
+	roleARNFlag := fs.String("role-arn", "", "Assume this role for all AWS requests")
+	externalIDFlag := fs.String("external-id", "", "External ID to use when assuming -role-arn")
+	endpointURLFlag := fs.String("endpoint-url", "", "Override the EC2 endpoint, e.g for a VPC endpoint")
+
+	return func() {
+		fetcher.Configure(fetcher.ClientConfig{
+			Profile:     *profileFlag,
+			RoleARN:     *roleARNFlag,
+			ExternalID:  *externalIDFlag,
+			EndpointURL: *endpointURLFlag,
+		})
+	}
+}
+
+// instanceFlags registers -instance and the filters applied to instance patterns, returning
+// a function that expands them once the flags are parsed
+func instanceFlags(fs *flag.FlagSet, usage string) func() ([]string, error) {
+	instanceFlag := fs.String("instance", "c4.large", usage+", or multiple comma delimited. Patterns like c4.*, m4*.large or family:compute are expanded from the instance catalog")
+	currentGenerationFlag := fs.Bool("current-generation", false, "Only expand instance patterns to current generation instance types")
+	archFlag := fs.String("arch", "", "Only expand instance patterns to instance types with this architecture, e.g x86_64 or i386")
+
+	return func() ([]string, error) {
+		return data.MatchInstanceTypes(strings.Split(*instanceFlag, ","), data.InstanceFilter{
+			CurrentGeneration: *currentGenerationFlag,
+			Arch:              *archFlag,
+		})
+	}
+}
+
+// productFlags registers -product, returning a function that splits it into product
+// descriptions once the flags are parsed
+func productFlags(fs *flag.FlagSet, usage string) func() []string {
+	productFlag := fs.String("product", "Linux/UNIX (Amazon VPC)", usage+" a particular product type, or multiple comma delimited")
+
+	return func() []string {
+		products := []string{}
+		for _, p := range strings.Split(*productFlag, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				products = append(products, p)
+			}
+		}
+		return products
+	}
+}
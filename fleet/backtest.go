@@ -0,0 +1,200 @@
+package fleet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/timerange"
+)
+
+// Strategy is a spot fleet allocation strategy
+type Strategy string
+
+const (
+	// LowestPrice launches into the cheapest pool
+	LowestPrice Strategy = "lowest-price"
+	// Diversified spreads instances evenly across pools
+	Diversified Strategy = "diversified"
+	// CapacityOptimized launches into the pool least likely to be interrupted. Spare capacity
+	// isn't in the price history, so the most stable pool is used instead.
+	CapacityOptimized Strategy = "capacity-optimized"
+	// PriceCapacityOptimized launches into the cheapest of the more stable half of the pools
+	PriceCapacityOptimized Strategy = "price-capacity-optimized"
+)
+
+// stabilityThreshold is the fraction of on-demand price counted as expensive when judging stability
+const stabilityThreshold = 0.8
+
+// Strategies are every strategy that can be backtested
+var Strategies = []Strategy{LowestPrice, Diversified, CapacityOptimized, PriceCapacityOptimized}
+
+// ParseStrategy returns the strategy with a name
+func ParseStrategy(name string) (Strategy, error) {
+	for _, s := range Strategies {
+		if string(s) == name {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown strategy %q", name)
+}
+
+// History is a pool along with its price history, sorted by time
+type History struct {
+	Pool
+	OnDemandPrice float64
+	Prices        data.SpotPriceSlice
+}
+
+// BacktestParams describes the fleet to simulate
+type BacktestParams struct {
+	// Instances is how many instances the fleet keeps running
+	Instances int
+	Range     timerange.Range
+	// Lookback is how much history stability is judged on, defaults to a day
+	Lookback time.Duration
+	// MaxPrice is the most paid for an instance as a fraction of its on-demand price,
+	// defaults to 1. Instances are interrupted when the spot price goes above it.
+	MaxPrice float64
+}
+
+// BacktestResult is how a strategy performed over the range
+type BacktestResult struct {
+	Strategy      Strategy `json:"strategy"`
+	InstanceHours int      `json:"instance_hours"`
+	// MissingHours is how many instance hours the fleet was short of capacity
+	MissingHours  int     `json:"missing_hours"`
+	Interruptions int     `json:"interruptions"`
+	SpotCost      float64 `json:"spot_cost"`
+	OnDemandCost  float64 `json:"on_demand_cost"`
+	Savings       float64 `json:"savings"`
+}
+
+// poolState is a pool at the start of an hour
+type poolState struct {
+	idx       int
+	price     float64
+	stability float64
+}
+
+// Backtest simulates a fleet that keeps params.Instances running over the range hour by hour.
+// Instances are launched with the strategy at the start of each hour into pools priced below
+// the max price. Instances in a pool whose price goes above the max price during an hour
+// are interrupted, aren't charged for that hour and are replaced at the start of the next.
+func Backtest(pools []History, strategy Strategy, params BacktestParams) (BacktestResult, error) {
+	result := BacktestResult{Strategy: strategy}
+
+	if params.Instances <= 0 {
+		return result, errors.New("instances must be positive")
+	}
+
+	if len(pools) == 0 {
+		return result, errors.New("no pools to launch instances into")
+	}
+
+	if params.Lookback == 0 {
+		params.Lookback = time.Hour * 24
+	}
+
+	if params.MaxPrice == 0 {
+		params.MaxPrice = 1
+	}
+
+	running := make([]int, len(pools))
+
+	for _, hour := range params.Range.Split(time.Hour) {
+		available := []poolState{}
+		for idx, p := range pools {
+			price, ok := p.Prices.PriceAt(hour[0])
+			if !ok || price > p.OnDemandPrice*params.MaxPrice {
+				continue
+			}
+
+			state := poolState{idx: idx, price: price}
+			if strategy == CapacityOptimized || strategy == PriceCapacityOptimized {
+				lookback := timerange.Range{hour[0].Add(-params.Lookback), hour[0]}
+				state.stability = p.Prices.Stability(lookback, p.OnDemandPrice, stabilityThreshold).Score
+			}
+			available = append(available, state)
+		}
+
+		total := 0
+		for _, n := range running {
+			total += n
+		}
+
+		if needed := params.Instances - total; needed > 0 && len(available) > 0 {
+			allocate(strategy, available, running, needed)
+		}
+
+		// a price that starts as the hour ends belongs to the next hour
+		during := timerange.Range{hour[0], hour[1].Add(-time.Nanosecond)}
+
+		for idx, p := range pools {
+			if running[idx] == 0 {
+				continue
+			}
+
+			if p.Prices.MaxDuring(during) > p.OnDemandPrice*params.MaxPrice {
+				result.Interruptions += running[idx]
+				running[idx] = 0
+				continue
+			}
+
+			result.InstanceHours += running[idx]
+			result.SpotCost += float64(running[idx]) * p.Prices.TimeWeightedAverage(hour)
+			result.OnDemandCost += float64(running[idx]) * p.OnDemandPrice
+		}
+	}
+
+	result.MissingHours = params.Instances*len(params.Range.Split(time.Hour)) - result.InstanceHours
+
+	if result.OnDemandCost > 0 {
+		result.Savings = ((result.OnDemandCost - result.SpotCost) / result.OnDemandCost) * 100
+	}
+
+	return result, nil
+}
+
+// allocate launches n instances into the available pools with the strategy
+func allocate(strategy Strategy, available []poolState, running []int, n int) {
+	byPrice := func(i, j int) bool {
+		return available[i].price < available[j].price
+	}
+
+	switch strategy {
+	case LowestPrice:
+		sort.SliceStable(available, byPrice)
+		running[available[0].idx] += n
+
+	case Diversified:
+		sort.SliceStable(available, byPrice)
+		for ; n > 0; n-- {
+			least := available[0].idx
+			for _, s := range available {
+				if running[s.idx] < running[least] {
+					least = s.idx
+				}
+			}
+			running[least]++
+		}
+
+	case CapacityOptimized:
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].stability > available[j].stability
+		})
+		running[available[0].idx] += n
+
+	case PriceCapacityOptimized:
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].stability > available[j].stability
+		})
+		stable := available[:(len(available)+1)/2]
+		sort.SliceStable(stable, func(i, j int) bool {
+			return stable[i].price < stable[j].price
+		})
+		running[stable[0].idx] += n
+	}
+}
@@ -0,0 +1,87 @@
+package fleet_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fleet"
+	"github.com/lox/ec2spot/timerange"
+)
+
+var t1 = time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+// hourlyPrices returns a price for every hour, using prices in turn
+func hourlyPrices(hours int, prices ...float64) data.SpotPriceSlice {
+	result := data.SpotPriceSlice{}
+	for h := 0; h < hours; h++ {
+		result = append(result, data.SpotPrice{Price: prices[h%len(prices)], Timestamp: t1.Add(time.Duration(h) * time.Hour)})
+	}
+	return result
+}
+
+func backtestPools() []fleet.History {
+	return []fleet.History{
+		// cheapest, but spikes above on-demand every fourth hour
+		{Pool: fleet.Pool{AvailabilityZone: "us-east-1a"}, OnDemandPrice: 0.1, Prices: hourlyPrices(8, 0.02, 0.02, 0.02, 0.2)},
+		// steady
+		{Pool: fleet.Pool{AvailabilityZone: "us-east-1b"}, OnDemandPrice: 0.1, Prices: hourlyPrices(8, 0.04)},
+	}
+}
+
+func TestBacktestLowestPrice(t *testing.T) {
+	result, err := fleet.Backtest(backtestPools(), fleet.LowestPrice, fleet.BacktestParams{
+		Instances: 2,
+		Range:     timerange.Range{t1, t1.Add(time.Hour * 8)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both instances run in us-east-1a and are interrupted by the spikes in hours 3 and 7
+	if result.Interruptions != 4 {
+		t.Fatalf("Expected 4 interruptions, got %d", result.Interruptions)
+	}
+
+	if result.InstanceHours != 12 || result.MissingHours != 4 {
+		t.Fatalf("Expected 12 instance hours and 4 missing, got %d and %d", result.InstanceHours, result.MissingHours)
+	}
+
+	if math.Abs(result.SpotCost-0.24) > 1e-9 {
+		t.Fatalf("Expected $0.24, got $%v", result.SpotCost)
+	}
+}
+
+func TestBacktestCapacityOptimizedAvoidsUnstablePools(t *testing.T) {
+	result, err := fleet.Backtest(backtestPools(), fleet.CapacityOptimized, fleet.BacktestParams{
+		Instances: 2,
+		Range:     timerange.Range{t1.Add(time.Hour * 4), t1.Add(time.Hour * 8)},
+		Lookback:  time.Hour * 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Interruptions != 0 {
+		t.Fatalf("Expected no interruptions, got %d", result.Interruptions)
+	}
+
+	if math.Abs(result.SpotCost-0.32) > 1e-9 || math.Abs(result.Savings-60) > 1e-9 {
+		t.Fatalf("Expected $0.32 and 60%% savings, got $%v and %v%%", result.SpotCost, result.Savings)
+	}
+}
+
+func TestBacktestDiversified(t *testing.T) {
+	result, err := fleet.Backtest(backtestPools(), fleet.Diversified, fleet.BacktestParams{
+		Instances: 2,
+		Range:     timerange.Range{t1, t1.Add(time.Hour * 3)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if math.Abs(result.SpotCost-0.18) > 1e-9 {
+		t.Fatalf("Expected one instance in each pool costing $0.18, got $%v", result.SpotCost)
+	}
+}
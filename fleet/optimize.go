@@ -0,0 +1,196 @@
+// Package fleet plans and simulates spot fleets spread across capacity pools, where a pool
+// is an instance type in an availability zone
+package fleet
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// epsilon absorbs floating point error when shares are summed
+const epsilon = 1e-9
+
+// Pool is an instance type in an availability zone, with its expected hourly price
+type Pool struct {
+	Region           string  `json:"region"`
+	InstanceType     string  `json:"instance_type"`
+	AvailabilityZone string  `json:"availability_zone"`
+	Product          string  `json:"product,omitempty"`
+	VCPU             int     `json:"vcpu"`
+	Price            float64 `json:"price"`
+}
+
+// PricePerVCPU returns the hourly price of a single vCPU
+func (p Pool) PricePerVCPU() float64 {
+	if p.VCPU == 0 {
+		return 0
+	}
+	return p.Price / float64(p.VCPU)
+}
+
+func (p Pool) String() string {
+	if p.Product != "" {
+		return fmt.Sprintf("%s %s %s %s", p.Region, p.AvailabilityZone, p.InstanceType, p.Product)
+	}
+	return fmt.Sprintf("%s %s %s", p.Region, p.AvailabilityZone, p.InstanceType)
+}
+
+// OptimizeParams describes the capacity needed and how it must be spread
+type OptimizeParams struct {
+	// Capacity is how many vCPUs are needed
+	Capacity int
+	// MaxShare is the largest fraction of Capacity any one pool can provide, e.g 0.5
+	MaxShare float64
+	// MinPools is the fewest pools the capacity can be spread across
+	MinPools int
+}
+
+// Allocation is the part of a plan provided by a single pool
+type Allocation struct {
+	Pool
+	Share      float64 `json:"share"`
+	Instances  int     `json:"instances"`
+	VCPUs      int     `json:"vcpus"`
+	HourlyCost float64 `json:"hourly_cost"`
+}
+
+// Plan is an allocation of capacity across pools
+type Plan struct {
+	Allocations []Allocation `json:"allocations"`
+	// VCPUs can exceed the requested capacity as pools provide whole instances
+	VCPUs      int     `json:"vcpus"`
+	HourlyCost float64 `json:"hourly_cost"`
+}
+
+// CostPerVCPU returns the blended hourly price of a vCPU across the plan
+func (p Plan) CostPerVCPU() float64 {
+	if p.VCPUs == 0 {
+		return 0
+	}
+	return p.HourlyCost / float64(p.VCPUs)
+}
+
+// Optimize allocates capacity to the cheapest pools per vCPU, filling each with whole instances
+// up to MaxShare of the capacity. If that uses fewer than MinPools pools, the cheapest
+// instances to give up are moved one at a time to the next cheapest unused pools. Pools
+// without a price are ignored.
+func Optimize(pools []Pool, params OptimizeParams) (Plan, error) {
+	if params.Capacity <= 0 {
+		return Plan{}, errors.New("capacity must be positive")
+	}
+
+	if params.MaxShare <= 0 || params.MaxShare > 1 {
+		return Plan{}, errors.New("max share must be more than 0 and at most 1")
+	}
+
+	candidates := []Pool{}
+	for _, p := range pools {
+		if p.VCPU > 0 && p.Price > 0 {
+			candidates = append(candidates, p)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].PricePerVCPU() != candidates[j].PricePerVCPU() {
+			return candidates[i].PricePerVCPU() < candidates[j].PricePerVCPU()
+		}
+		return candidates[i].String() < candidates[j].String()
+	})
+
+	limit := params.MaxShare * float64(params.Capacity)
+	remaining := params.Capacity
+	instances := make([]int, len(candidates))
+	used := 0
+
+	for idx, p := range candidates {
+		if remaining <= 0 {
+			break
+		}
+
+		n := int(math.Min(math.Floor(limit/float64(p.VCPU)+epsilon), math.Ceil(float64(remaining)/float64(p.VCPU))))
+		if n <= 0 {
+			continue
+		}
+
+		instances[idx] = n
+		remaining -= n * p.VCPU
+		used = idx + 1
+	}
+
+	if remaining > 0 {
+		return Plan{}, fmt.Errorf("%d pools with prices can't provide %d vCPUs with at most %.0f%% of capacity each",
+			len(candidates), params.Capacity, params.MaxShare*100)
+	}
+
+	// spread onto more pools, moving the instance that costs least to replace each time
+	for count := countPools(instances); count < params.MinPools; count++ {
+		next := -1
+		for idx := used; idx < len(candidates); idx++ {
+			if instances[idx] == 0 && float64(candidates[idx].VCPU) <= limit+epsilon {
+				next = idx
+				break
+			}
+		}
+		if next == -1 {
+			return Plan{}, fmt.Errorf("need at least %d pools with prices, only %d can be used", params.MinPools, count)
+		}
+
+		target := candidates[next]
+		donor, replacement := -1, 0
+		var cheapest float64
+
+		for idx, n := range instances {
+			if n < 2 {
+				continue
+			}
+			needed := int(math.Ceil(float64(candidates[idx].VCPU) / float64(target.VCPU)))
+			if cost := float64(needed)*target.Price - candidates[idx].Price; donor == -1 || cost < cheapest {
+				donor, replacement, cheapest = idx, needed, cost
+			}
+		}
+
+		if donor == -1 {
+			// every pool has a single instance, so add capacity rather than move it
+			replacement = 1
+		} else {
+			instances[donor]--
+		}
+
+		instances[next] = replacement
+		used = next + 1
+	}
+
+	plan := Plan{}
+	for idx, n := range instances {
+		if n == 0 {
+			continue
+		}
+
+		p := candidates[idx]
+		a := Allocation{
+			Pool:       p,
+			Share:      float64(n*p.VCPU) / float64(params.Capacity),
+			Instances:  n,
+			VCPUs:      n * p.VCPU,
+			HourlyCost: float64(n) * p.Price,
+		}
+
+		plan.Allocations = append(plan.Allocations, a)
+		plan.VCPUs += a.VCPUs
+		plan.HourlyCost += a.HourlyCost
+	}
+
+	return plan, nil
+}
+
+func countPools(instances []int) int {
+	count := 0
+	for _, n := range instances {
+		if n > 0 {
+			count++
+		}
+	}
+	return count
+}
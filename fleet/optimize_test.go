@@ -0,0 +1,93 @@
+package fleet_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/lox/ec2spot/fleet"
+)
+
+var pools = []fleet.Pool{
+	{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1a", VCPU: 2, Price: 0.04},
+	{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1b", VCPU: 2, Price: 0.03},
+	{Region: "us-east-1", InstanceType: "c4.xlarge", AvailabilityZone: "us-east-1a", VCPU: 4, Price: 0.05},
+	{Region: "us-east-1", InstanceType: "m4.large", AvailabilityZone: "us-east-1a", VCPU: 2, Price: 0.08},
+	{Region: "us-east-1", InstanceType: "m4.large", AvailabilityZone: "us-east-1b", VCPU: 2},
+}
+
+func TestOptimizeFillsCheapestPools(t *testing.T) {
+	plan, err := fleet.Optimize(pools, fleet.OptimizeParams{Capacity: 16, MaxShare: 0.5, MinPools: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(plan.Allocations); l != 2 {
+		t.Fatalf("Expected 2 pools, got %d", l)
+	}
+
+	// c4.xlarge is $0.0125 per vCPU, c4.large in us-east-1b is $0.015
+	first, second := plan.Allocations[0], plan.Allocations[1]
+	if first.InstanceType != "c4.xlarge" || first.Instances != 2 {
+		t.Fatalf("Expected 2 c4.xlarge first, got %d %s", first.Instances, first.InstanceType)
+	}
+
+	if second.AvailabilityZone != "us-east-1b" || second.Instances != 4 {
+		t.Fatalf("Expected 4 c4.large in us-east-1b second, got %d in %s", second.Instances, second.AvailabilityZone)
+	}
+
+	if plan.VCPUs != 16 || math.Abs(plan.HourlyCost-0.22) > 1e-9 {
+		t.Fatalf("Expected 16 vCPUs for $0.22, got %d for $%v", plan.VCPUs, plan.HourlyCost)
+	}
+}
+
+func TestOptimizeRespectsMinPools(t *testing.T) {
+	plan, err := fleet.Optimize(pools, fleet.OptimizeParams{Capacity: 12, MaxShare: 1, MinPools: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(plan.Allocations); l != 3 {
+		t.Fatalf("Expected 3 pools, got %d", l)
+	}
+
+	// a c4.xlarge is replaced by a c4.large in each extra pool, the rest stays in the cheapest
+	if first := plan.Allocations[0]; first.InstanceType != "c4.xlarge" || first.Instances != 2 {
+		t.Fatalf("Expected 2 c4.xlarge to stay in the cheapest pool, got %d %s", first.Instances, first.InstanceType)
+	}
+
+	if plan.VCPUs != 12 || math.Abs(plan.HourlyCost-0.17) > 1e-9 {
+		t.Fatalf("Expected 12 vCPUs for $0.17, got %d for $%v", plan.VCPUs, plan.HourlyCost)
+	}
+}
+
+func TestOptimizeMovesLeastCapacityToReachMinPools(t *testing.T) {
+	plan, err := fleet.Optimize(pools, fleet.OptimizeParams{Capacity: 16, MaxShare: 0.5, MinPools: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(plan.Allocations); l != 3 {
+		t.Fatalf("Expected 3 pools, got %d", l)
+	}
+
+	// the cheapest pool keeps its full share rather than being held to a third
+	if first := plan.Allocations[0]; first.InstanceType != "c4.xlarge" || first.Share != 0.5 {
+		t.Fatalf("Expected c4.xlarge to provide 50%%, got %v of %s", first.Share, first.InstanceType)
+	}
+
+	// one c4.large moves from us-east-1b to us-east-1a
+	if second, third := plan.Allocations[1], plan.Allocations[2]; second.Instances != 3 || third.Instances != 1 {
+		t.Fatalf("Expected 3 and 1 c4.large, got %d and %d", second.Instances, third.Instances)
+	}
+
+	if plan.VCPUs != 16 || math.Abs(plan.HourlyCost-0.23) > 1e-9 {
+		t.Fatalf("Expected 16 vCPUs for $0.23, got %d for $%v", plan.VCPUs, plan.HourlyCost)
+	}
+}
+
+func TestOptimizeNeedsEnoughPools(t *testing.T) {
+	// the m4.large pool without a price doesn't count
+	if _, err := fleet.Optimize(pools, fleet.OptimizeParams{Capacity: 8, MaxShare: 0.2}); err == nil {
+		t.Fatalf("Expected an error with fewer than 5 priced pools")
+	}
+}
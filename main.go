@@ -6,75 +6,206 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aybabtme/uniplot/histogram"
 	"github.com/lox/ec2spot/data"
 	"github.com/lox/ec2spot/fetcher"
+	"github.com/lox/ec2spot/store"
 	"github.com/lox/ec2spot/timerange"
+	"github.com/lox/ec2spot/workload"
 )
 
 func main() {
-	daysFlag := flag.Int("days", 7, "How many days to go back")
-	instanceFlag := flag.String("instance", "c4.large", "Show results for a particular instance type, or multiple comma delimited")
-	productFlag := flag.String("product", "Linux/UNIX (Amazon VPC)", "Show results for a particular product type")
-	regionFlag := flag.String("region", "us-east-1", "Show results for a particular region")
-	azsFlag := flag.String("azs", "", "Only include specific availability zones (e.g a,b,c)")
-	concurrencyFlag := flag.Int("concurrency", 10, "How many concurrent AWS requests to make")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "watch":
+			runWatchCommand(os.Args[2:])
+			return
+		case "serve":
+			runServeCommand(os.Args[2:])
+			return
+		case "exporter":
+			runExporterCommand(os.Args[2:])
+			return
+		case "sync":
+			runSyncCommand(os.Args[2:])
+			return
+		case "optimize":
+			runOptimizeCommand(os.Args[2:])
+			return
+		case "backtest":
+			runBacktestCommand(os.Args[2:])
+			return
+		case "suggest-max-price":
+			runSuggestMaxPriceCommand(os.Args[2:])
+			return
+		}
+	}
+
+	loadPrices := priceFlags(flag.CommandLine, "Show results for")
 	maxBidFlag := flag.Float64("max-bid", 0, "Maximum bid to make in estimates")
+	utilizationFlag := flag.Float64("utilization", 100, "Percentage of hours the instance runs in estimates")
+	scheduleFlag := flag.String("schedule", "", "Estimate costs for jobs started by a cron expression in UTC, e.g \"0 9 * * 1-5\"")
+	jobDurationFlag := flag.String("job-duration", "1h", "How long scheduled jobs run, or a weighted distribution like 10m:70,30m:25,2h:5")
+	usageFlag := flag.String("usage", "", "Estimate costs for the usage in a csv file of start times and end times or hours")
+	commitmentRatesFlag := flag.String("commitment-rates", "", "Compare estimates to reserved instance and savings plan rates read from this json file")
+	thresholdFlag := flag.Float64("stability-threshold", 80, "Percentage of on-demand price that counts as expensive in stability scores")
+	spikeFactorFlag := flag.Float64("spike-factor", 2, "How many times the rolling median price counts as a spike")
+	spikeWindowFlag := flag.Duration("spike-window", time.Hour*24, "How far back the rolling median for spike detection looks")
+	jsonFlag := flag.Bool("json", false, "Output the report as JSON")
+	configureClient := clientFlags(flag.CommandLine)
 	flag.Parse()
+	configureClient()
 
-	regions := strings.Split(*regionFlag, ",")
-	azs := parseAvailabilityZones(regions, *azsFlag)
-	instanceTypes := strings.Split(*instanceFlag, ",")
-
-	prices, err := runAnalysis(context.Background(), analysisParams{
-		InstanceTypes:     instanceTypes,
-		Regions:           regions,
-		AvailabilityZones: azs,
-		Concurrency:       *concurrencyFlag,
-		Product:           *productFlag,
-		Days:              *daysFlag,
-	})
+	var commitmentRates data.CommitmentRates
+	if *commitmentRatesFlag != "" {
+		var err error
+		if commitmentRates, err = data.LoadCommitmentRates(*commitmentRatesFlag); err != nil {
+			log.Fatal(err)
+		}
+	}
 
+	usage, err := parseWorkload(*scheduleFlag, *jobDurationFlag, *usageFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, region := range regions {
-		for _, instanceType := range instanceTypes {
-			foundAZs := prices.AvailabilityZones()
-			sliced := prices.
-				ByRegion(region).
-				ByInstanceType(instanceType)
-
-			fmt.Printf("%-20s%s\n", "Region:", region)
-			fmt.Printf("%-20s%s\n", "Instance Type:", instanceType)
+	prices, params, err := loadPrices()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			info, err := data.GetInstanceTypeInfo(region, instanceType)
-			if err != nil {
-				log.Fatal(err)
+	tr := params.Range
+
+	ranks := []stabilityRank{}
+	reports := []instanceReport{}
+
+	for _, region := range params.Regions {
+		for _, instanceType := range params.InstanceTypes {
+			for _, product := range params.Products {
+				foundAZs := prices.AvailabilityZones(region, instanceType, product)
+				sliced := prices.Select(region, instanceType, product)
+
+				// regions from a group like us-* can include some instance types and not others
+				if len(foundAZs) == 0 && !data.OfferedIn(region, instanceType) {
+					continue
+				}
+
+				info, err := data.GetInstanceTypeInfo(region, instanceType, product)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				report := instanceReport{
+					Region:        region,
+					InstanceType:  instanceType,
+					Product:       product,
+					OnDemandPrice: info.Price,
+					Missing:       missingReports(params.Failures, region, instanceType, product, params.ZoneIDs),
+				}
+
+				if !*jsonFlag {
+					fmt.Printf("%-20s%s\n", "Region:", region)
+					fmt.Printf("%-20s%s\n", "Instance Type:", instanceType)
+					fmt.Printf("%-20s%s\n", "Product:", product)
+					showOnDemandPrice(info.Price, product)
+					showMissing(report.Missing)
+
+					fmt.Printf("\nAll Availability Zones %s\n", strings.Join(foundAZs, ","))
+					showHistograph(sliced)
+				}
+
+				rank := stabilityRank{Region: region, InstanceType: instanceType, Product: product}
+
+				for _, az := range foundAZs {
+					byAz := prices.Series(region, instanceType, product, az)
+
+					stability := byAz.Stability(tr, info.Price, *thresholdFlag/100)
+					spikes := byAz.Spikes(tr, data.SpikeParams{
+						Window:   *spikeWindowFlag,
+						Factor:   *spikeFactorFlag,
+						OnDemand: info.Price,
+					})
+
+					if !*jsonFlag {
+						fmt.Printf("\nAvailability Zone %s\n", az)
+						showHistograph(byAz)
+						fmt.Printf("Stability: %s\n", stability)
+						showSpikes(spikes)
+					}
+
+					if len(byAz) > 0 {
+						report.AvailabilityZones = append(report.AvailabilityZones, availabilityZoneReport{
+							AvailabilityZone: az,
+							Stability:        stability,
+							Spikes:           spikes,
+						})
+					}
+
+					if len(byAz) > 0 && (rank.AvailabilityZone == "" || stability.Score > rank.Stability.Score) {
+						rank.AvailabilityZone = az
+						rank.Stability = stability
+					}
+				}
+
+				ranks = append(ranks, rank)
+
+				estimate := calculateCost(costEstimateParams{
+					Days:         params.Days,
+					Range:        tr,
+					InstanceInfo: info,
+					Prices:       sliced,
+					MaxBid:       *maxBidFlag,
+					Utilization:  *utilizationFlag / 100,
+					Workload:     usage,
+					Commitments:  commitmentRates.Lookup(region, product, instanceType),
+				})
+				report.Estimate = &estimate
+
+				if !*jsonFlag {
+					showCostEstimate(estimate)
+				}
+
+				reports = append(reports, report)
 			}
+		}
+	}
 
-			fmt.Printf("%-20s$%.6f\n", "On-Demand Price:", info.Price)
+	if *jsonFlag {
+		if err := writeJSONReport(os.Stdout, reports); err != nil {
+			log.Fatal(err)
+		}
+	} else if len(ranks) > 1 {
+		showStabilityRanking(ranks)
+	}
 
-			fmt.Printf("\nAll Availability Zones %s\n", strings.Join(foundAZs, ","))
-			showHistograph(sliced)
+	exitIfIncomplete(params)
+}
 
-			for _, az := range foundAZs {
-				fmt.Printf("\nAvailability Zone %s\n", az)
-				showHistograph(sliced.ByAvailabilityZone(az))
-			}
+// exitPartialResults is the exit status when -continue-on-error produced an incomplete report
+const exitPartialResults = 3
 
-			estimateCost(costEstimateParams{
-				Days:         *daysFlag,
-				InstanceInfo: info,
-				Prices:       sliced,
-				MaxBid:       *maxBidFlag,
-			})
-		}
+type stabilityRank struct {
+	Region           string
+	InstanceType     string
+	Product          string
+	AvailabilityZone string
+	Stability        data.Stability
+}
+
+// showStabilityRanking prints instance types ordered by the score of their most stable availability zone
+func showStabilityRanking(ranks []stabilityRank) {
+	sort.SliceStable(ranks, func(i, j int) bool {
+		return ranks[i].Stability.Score > ranks[j].Stability.Score
+	})
+
+	fmt.Printf("\nStability ranking (most stable first)\n")
+	for idx, r := range ranks {
+		fmt.Printf("%2d. %-12s %-15s %-15s %-25s %.1f\n",
+			idx+1, r.InstanceType, r.Region, r.AvailabilityZone, r.Product, r.Stability.Score)
 	}
 }
 
@@ -83,70 +214,255 @@ type analysisParams struct {
 	InstanceTypes     []string
 	Regions           []string
 	AvailabilityZones []string
-	Product           string
+	Products          []string
 	Days              int
 	Concurrency       int
+	Progress          *fetcher.Progress
+	Failures          *fetcher.Failures
+	// ZoneIDs maps zone names to ids when prices are grouped by zone id
+	ZoneIDs map[string]string
 }
 
 type costEstimateParams struct {
 	Days         int
+	Range        timerange.Range
 	InstanceInfo data.InstanceTypeInfo
 	Prices       data.SpotPriceSlice
 	MaxBid       float64
+	// Utilization is the share of hours the instance runs, all of them if zero
+	Utilization float64
+	// Workload is when the instance runs, overriding Utilization
+	Workload workload.Workload
+	// Commitments are reserved instance and savings plan rates to compare against
+	Commitments []data.Commitment
+}
+
+type costEstimate struct {
+	Days          int                  `json:"days"`
+	Hours         int                  `json:"hours"`
+	OnDemandPrice float64              `json:"on_demand_price,omitempty"`
+	OnDemandCost  float64              `json:"on_demand_cost,omitempty"`
+	MaxBid        float64              `json:"max_bid"`
+	SpotCost      float64              `json:"spot_cost"`
+	Savings       float64              `json:"savings,omitempty"`
+	TimesOutbid   int                  `json:"times_outbid"`
+	UsageHours    float64              `json:"usage_hours"`
+	Utilization   float64              `json:"utilization"`
+	Commitments   []commitmentEstimate `json:"commitments,omitempty"`
+}
+
+// commitmentEstimate is the cost of a commitment over the same hours as a cost estimate. A
+// commitment is paid for every hour, so it only beats spot and on-demand when the instance
+// runs for more than the break even share of hours.
+type commitmentEstimate struct {
+	Name              string  `json:"name"`
+	HourlyRate        float64 `json:"hourly_rate"`
+	Cost              float64 `json:"cost"`
+	SpotBreakEven     float64 `json:"spot_break_even_utilization"`
+	OnDemandBreakEven float64 `json:"on_demand_break_even_utilization"`
 }
 
-func estimateCost(params costEstimateParams) {
-	var totalSpotCost, totalOnDemandCost float64
+func calculateCost(params costEstimateParams) costEstimate {
+	var totalSpotCost, usageHours float64
+	// what running every hour would cost, which commitments are compared against
+	var fullSpotCost float64
 	var timesOutbid int
 
-	tr := timerange.DaysAgo(time.Now(), params.Days)
+	tr := params.Range
+	if tr[0].IsZero() {
+		tr = timerange.DaysAgo(time.Now(), params.Days)
+	}
+
 	hours := tr.Split(time.Hour)
 	maxBid := params.Prices.Max()
 
-	totalOnDemandCost = params.InstanceInfo.Price * float64(len(hours))
-
 	if params.MaxBid > 0 && maxBid > params.MaxBid {
 		maxBid = params.MaxBid
 	}
 
-	for _, bucket := range params.Prices.Buckets(hours) {
-		outBid := true
+	utilization := params.Utilization
+	if utilization <= 0 {
+		utilization = 1
+	}
 
-		for _, az := range bucket.Prices.AvailabilityZones() {
-			if maxBid >= bucket.Prices.ByAvailabilityZone(az).Max() {
-				outBid = false
-				break
-			}
+	usage := params.Workload
+	if s, ok := usage.(workload.Schedule); ok {
+		// match the schedule once for the whole range rather than again for every hour
+		usage = s.Plan(tr)
+	}
+
+	zones := []data.SpotPriceSlice{}
+	for _, az := range params.Prices.AvailabilityZones() {
+		zones = append(zones, params.Prices.ByAvailabilityZone(az).SortByTime())
+	}
+
+	for _, hour := range hours {
+		used := utilization
+		if usage != nil {
+			used = usage.Usage(hour)
 		}
 
-		if !outBid {
-			totalSpotCost += bucket.Prices.Max()
-		} else {
+		usageHours += used
+
+		if price, ok := spotPriceForHour(zones, hour, maxBid); ok {
+			fullSpotCost += price
+			totalSpotCost += price * used
+		} else if used > 0 {
 			timesOutbid++
 		}
 	}
 
+	fullOnDemandCost := params.InstanceInfo.Price * float64(len(hours))
+	totalOnDemandCost := params.InstanceInfo.Price * usageHours
+
+	var savings float64
+	if totalOnDemandCost > 0 {
+		savings = ((totalOnDemandCost - totalSpotCost) / totalOnDemandCost) * 100
+	}
+
+	commitments := []commitmentEstimate{}
+	for _, c := range params.Commitments {
+		cost := c.HourlyRate * float64(len(hours))
+		commitments = append(commitments, commitmentEstimate{
+			Name:              c.Name,
+			HourlyRate:        c.HourlyRate,
+			Cost:              cost,
+			SpotBreakEven:     breakEven(cost, fullSpotCost),
+			OnDemandBreakEven: breakEven(cost, fullOnDemandCost),
+		})
+	}
+
+	return costEstimate{
+		Days:          params.Days,
+		Hours:         len(hours),
+		OnDemandPrice: params.InstanceInfo.Price,
+		OnDemandCost:  totalOnDemandCost,
+		MaxBid:        maxBid,
+		SpotCost:      totalSpotCost,
+		Savings:       savings,
+		TimesOutbid:   timesOutbid,
+		UsageHours:    usageHours,
+		Utilization:   usageHours / float64(len(hours)),
+		Commitments:   commitments,
+	}
+}
+
+// spotPriceForHour returns the average price paid during an hour in the cheapest zone whose
+// price stayed at or below maxBid, or false if every zone was outbid or had no price
+func spotPriceForHour(zones []data.SpotPriceSlice, hour timerange.Range, maxBid float64) (float64, bool) {
+	// a price change at the end of the hour belongs to the next one
+	hour[1] = hour[1].Add(-time.Nanosecond)
+
+	var cheapest, paid float64
+	var found bool
+
+	for _, z := range zones {
+		max, average, ok := z.PriceDuring(hour)
+		if !ok || max > maxBid {
+			continue
+		}
+		if !found || max < cheapest {
+			cheapest, paid, found = max, average, true
+		}
+	}
+
+	return paid, found
+}
+
+// parseWorkload builds the workload to estimate costs for from either a schedule or a usage
+// file, or returns nil to estimate for every hour
+func parseWorkload(schedule, jobDuration, usagePath string) (workload.Workload, error) {
+	switch {
+	case schedule != "" && usagePath != "":
+		return nil, fmt.Errorf("-schedule and -usage can't be used together")
+	case usagePath != "":
+		return workload.LoadUsage(usagePath)
+	case schedule != "":
+		cron, err := workload.ParseCron(schedule)
+		if err != nil {
+			return nil, err
+		}
+		durations, err := workload.ParseDistribution(jobDuration)
+		if err != nil {
+			return nil, err
+		}
+		return workload.Schedule{Cron: cron, Durations: durations}, nil
+	}
+	return nil, nil
+}
+
+// breakEven returns the share of hours an instance must run for a fixed commitment cost to
+// match paying fullCost for running every hour. Above one the commitment never pays off, zero
+// means there is nothing to compare against.
+func breakEven(commitmentCost, fullCost float64) float64 {
+	if fullCost == 0 {
+		return 0
+	}
+	return commitmentCost / fullCost
+}
+
+func showOnDemandPrice(price float64, product string) {
+	if price == 0 {
+		fmt.Printf("%-20s%s\n", "On-Demand Price:", "unknown for "+product+", skipping on-demand comparisons")
+		return
+	}
+	fmt.Printf("%-20s$%.6f\n", "On-Demand Price:", price)
+}
+
+func showCostEstimate(estimate costEstimate) {
 	fmt.Println("")
-	fmt.Printf("Time range is %d days, or %d hours\n", params.Days, len(hours))
-	fmt.Printf("At on-demand price of $%.4g (across all azs): $%.4g\n",
-		params.InstanceInfo.Price, totalOnDemandCost)
-	fmt.Printf("At maximum spot bid of $%.4g (across all azs): $%.4g (%%%.2f of on-demand)\n",
-		maxBid, totalSpotCost, ((totalOnDemandCost-totalSpotCost)/totalOnDemandCost)*100)
-	fmt.Printf("Time outbid: %d\n", timesOutbid)
+	fmt.Printf("Time range is %d days, or %d hours\n", estimate.Days, estimate.Hours)
+	if estimate.Utilization != 1 {
+		fmt.Printf("Running %.4g instance hours, %.4g%% of the time range\n",
+			estimate.UsageHours, estimate.Utilization*100)
+	}
+	if estimate.OnDemandPrice > 0 {
+		fmt.Printf("At on-demand price of $%.4g (across all azs): $%.4g\n",
+			estimate.OnDemandPrice, estimate.OnDemandCost)
+		fmt.Printf("At maximum spot bid of $%.4g (across all azs): $%.4g (%%%.2f of on-demand)\n",
+			estimate.MaxBid, estimate.SpotCost, estimate.Savings)
+	} else {
+		fmt.Printf("At maximum spot bid of $%.4g (across all azs): $%.4g (on-demand price unknown)\n",
+			estimate.MaxBid, estimate.SpotCost)
+	}
+	fmt.Printf("Time outbid: %d\n", estimate.TimesOutbid)
+
+	for _, c := range estimate.Commitments {
+		fmt.Printf("At %s rate of $%.4g: $%.4g, cheaper than spot %s and on-demand %s\n",
+			c.Name, c.HourlyRate, c.Cost, formatBreakEven(c.SpotBreakEven), formatBreakEven(c.OnDemandBreakEven))
+	}
+}
+
+func formatBreakEven(utilization float64) string {
+	switch {
+	case utilization == 0:
+		return "n/a"
+	case utilization > 1:
+		return "never"
+	default:
+		return fmt.Sprintf("above %.1f%% utilization", utilization*100)
+	}
 }
 
-func runAnalysis(ctx context.Context, params analysisParams) (data.SpotPriceSlice, error) {
+// runAnalysis fetches prices, indexing them as they arrive
+func runAnalysis(ctx context.Context, params analysisParams) (*data.PriceIndex, error) {
 	results, g := fetcher.BatchFetch(ctx, params.Concurrency, fetcher.BatchFetchSpec{
 		InstanceTypes:     params.InstanceTypes,
 		Regions:           params.Regions,
 		AvailabilityZones: params.AvailabilityZones,
-		Product:           params.Product,
+		Products:          params.Products,
 		Days:              params.Days,
+		Range:             params.Range,
+		Progress:          params.Progress,
+		Failures:          params.Failures,
 	})
 
-	prices := data.SpotPriceSlice{}
+	prices := data.NewPriceIndex()
 	for price := range results {
-		prices = append(prices, price)
+		if params.ZoneIDs != nil {
+			price.AvailabilityZoneID = params.ZoneIDs[price.AvailabilityZone]
+		}
+		prices.Add(price)
 	}
 
 	if err := g.Wait(); err != nil {
@@ -156,33 +472,98 @@ func runAnalysis(ctx context.Context, params analysisParams) (data.SpotPriceSlic
 	return prices, nil
 }
 
-var reAz = regexp.MustCompile(`([a-z]+\-[a-z]+-[0-9])([a-z])?$`)
+// queryStore reads the prices an analysis would have fetched from a local store, across
+// all availability zones
+func queryStore(path string, params analysisParams) (data.SpotPriceSlice, error) {
+	st, err := store.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer st.Close()
 
-func parseAvailabilityZones(regions []string, azsFlag string) []string {
-	azs := []string{}
+	return st.Query(store.Query{
+		Regions:       params.Regions,
+		InstanceTypes: params.InstanceTypes,
+		Products:      params.Products,
+		Range:         params.Range,
+	})
+}
+
+// importFiles reads price history from json or csv files, assuming defaultProduct for
+// prices from files without a product column
+func importFiles(paths []string, defaultProduct string) (data.SpotPriceSlice, error) {
+	prices := data.SpotPriceSlice{}
+
+	for _, path := range paths {
+		imported, err := data.ImportFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range imported {
+			if p.ProductDescription == "" {
+				p.ProductDescription = defaultProduct
+			}
+			prices = append(prices, p)
+		}
+	}
+
+	if len(prices) == 0 {
+		return nil, fmt.Errorf("no prices found in %s", strings.Join(paths, ","))
+	}
+
+	return prices, nil
+}
 
-	for _, s := range strings.Split(azsFlag, ",") {
-		if s != "" {
-			azs = append(azs, regions[0]+s)
+// filterPrices returns the prices that an analysis would have fetched
+func filterPrices(prices data.SpotPriceSlice, params analysisParams) data.SpotPriceSlice {
+	filtered := data.SpotPriceSlice{}
+	prices = prices.SortByTime()
+
+	for _, region := range params.Regions {
+		for _, instanceType := range params.InstanceTypes {
+			for _, product := range params.Products {
+				sliced := prices.ByRegion(region).ByInstanceType(instanceType).ByProduct(product).Subset(params.Range)
+				if len(params.AvailabilityZones) == 0 {
+					filtered = append(filtered, sliced...)
+					continue
+				}
+				for _, az := range params.AvailabilityZones {
+					filtered = append(filtered, sliced.ByAvailabilityZone(az)...)
+				}
+			}
 		}
 	}
 
-	return azs
+	return filtered
 }
 
 func formatPrice(v float64) string {
 	return fmt.Sprintf("%.6g", v)
 }
 
-func showHistograph(prices data.SpotPriceSlice) error {
-	bins := 3
+func priceHistogram(prices data.SpotPriceSlice, bins int) histogram.Histogram {
 	data := []float64{}
 
 	for _, p := range prices {
 		data = append(data, p.Price)
 	}
 
-	hist := histogram.Hist(bins, data)
+	return histogram.Hist(bins, data)
+}
+
+func showHistograph(prices data.SpotPriceSlice) error {
+	hist := priceHistogram(prices, 3)
 	maxWidth := 40
 	return histogram.Fprintf(os.Stdout, hist, histogram.Linear(maxWidth), formatPrice)
 }
+
+func showSpikes(spikes []data.Spike) {
+	if len(spikes) == 0 {
+		return
+	}
+
+	fmt.Printf("Spikes: %d\n", len(spikes))
+	for _, spike := range spikes {
+		fmt.Printf("  %s\n", spike)
+	}
+}
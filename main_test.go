@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/timerange"
+	"github.com/lox/ec2spot/workload"
+)
+
+func TestCalculateCostWithCommitments(t *testing.T) {
+	t1 := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	estimate := calculateCost(costEstimateParams{
+		Range:        timerange.Range{t1, t1.Add(time.Hour * 10)},
+		InstanceInfo: data.InstanceTypeInfo{Price: 0.1},
+		Prices: data.SpotPriceSlice{
+			{AvailabilityZone: "us-east-1a", Price: 0.04, Timestamp: t1},
+			{AvailabilityZone: "us-east-1a", Price: 0.04, Timestamp: t1.Add(time.Hour * 9)},
+		},
+		Utilization: 0.5,
+		Commitments: []data.Commitment{{Name: "1yr Reserved Instance", HourlyRate: 0.06}},
+	})
+
+	if estimate.OnDemandCost != 0.5 {
+		t.Fatalf("Expected half of 10 on-demand hours to cost $0.5, got %v", estimate.OnDemandCost)
+	}
+
+	c := estimate.Commitments[0]
+	if c.Cost != 0.6 {
+		t.Fatalf("Expected the commitment to cost $0.6 for every hour, got %v", c.Cost)
+	}
+
+	if c.OnDemandBreakEven != 0.6 {
+		t.Fatalf("Expected on-demand to break even at 60%% utilization, got %v", c.OnDemandBreakEven)
+	}
+
+	// spot at $0.04 for every hour costs $0.4, so the commitment never pays off
+	if math.Abs(c.SpotBreakEven-1.5) > 1e-9 {
+		t.Fatalf("Expected spot to break even at 150%% utilization, got %v", c.SpotBreakEven)
+	}
+}
+
+func TestCalculateCostForWorkload(t *testing.T) {
+	t1 := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	estimate := calculateCost(costEstimateParams{
+		Range:        timerange.Range{t1, t1.Add(time.Hour * 4)},
+		InstanceInfo: data.InstanceTypeInfo{Price: 0.1},
+		Prices: data.SpotPriceSlice{
+			{AvailabilityZone: "us-east-1a", Price: 0.02, Timestamp: t1},
+			{AvailabilityZone: "us-east-1a", Price: 0.02, Timestamp: t1.Add(time.Hour * 4)},
+		},
+		Workload: workload.Runs{{Start: t1.Add(time.Hour), End: t1.Add(time.Hour * 3)}},
+	})
+
+	if estimate.UsageHours != 2 || estimate.Utilization != 0.5 {
+		t.Fatalf("Expected 2 hours of usage, got %v (%v)", estimate.UsageHours, estimate.Utilization)
+	}
+
+	if estimate.OnDemandCost != 0.2 {
+		t.Fatalf("Expected on-demand to cost $0.2 for the hours used, got %v", estimate.OnDemandCost)
+	}
+
+	// the price only changes once, but is in effect for every hour
+	if math.Abs(estimate.SpotCost-0.04) > 1e-9 {
+		t.Fatalf("Expected spot to cost $0.04 for the hours used, got %v", estimate.SpotCost)
+	}
+
+	if estimate.TimesOutbid != 0 {
+		t.Fatalf("Expected never to be outbid, got %d", estimate.TimesOutbid)
+	}
+}
+
+func TestCalculateCostAgreesWithSuggestedMaxPrice(t *testing.T) {
+	t1 := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+	tr := timerange.Range{t1, t1.Add(time.Hour * 10)}
+
+	prices := data.SpotPriceSlice{
+		{AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: t1},
+		{AvailabilityZone: "us-east-1a", Price: 0.5, Timestamp: t1.Add(time.Hour * 9)},
+	}
+
+	suggestion := data.SuggestMaxPrice([]data.SpotPriceSlice{prices}, tr, 0.9)
+
+	estimate := calculateCost(costEstimateParams{
+		Range:        tr,
+		InstanceInfo: data.InstanceTypeInfo{Price: 1},
+		Prices:       prices,
+		MaxBid:       suggestion.MaxPrice,
+	})
+
+	outbid := float64(estimate.TimesOutbid) / float64(estimate.Hours)
+	if math.Abs(outbid-(1-suggestion.Availability)) > 1e-9 {
+		t.Fatalf("Expected to be outbid %v of hours at $%v, got %d of %d",
+			1-suggestion.Availability, suggestion.MaxPrice, estimate.TimesOutbid, estimate.Hours)
+	}
+
+	if math.Abs(estimate.SpotCost-0.9) > 1e-9 {
+		t.Fatalf("Expected 9 hours at $0.1, got %v", estimate.SpotCost)
+	}
+}
+
+func TestCalculateCostUsesCheapestZone(t *testing.T) {
+	t1 := time.Date(2017, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	estimate := calculateCost(costEstimateParams{
+		Range:        timerange.Range{t1, t1.Add(time.Hour * 2)},
+		InstanceInfo: data.InstanceTypeInfo{Price: 1},
+		Prices: data.SpotPriceSlice{
+			{AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: t1},
+			{AvailabilityZone: "us-east-1a", Price: 0.3, Timestamp: t1.Add(time.Minute * 90)},
+			{AvailabilityZone: "us-east-1b", Price: 0.2, Timestamp: t1},
+		},
+		MaxBid: 0.25,
+	})
+
+	// us-east-1a for the first hour, then us-east-1b once 1a is priced above the max bid
+	if math.Abs(estimate.SpotCost-0.3) > 1e-9 {
+		t.Fatalf("Expected $0.1 then $0.2, got %v", estimate.SpotCost)
+	}
+
+	if estimate.TimesOutbid != 0 {
+		t.Fatalf("Expected never to be outbid, got %d", estimate.TimesOutbid)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/lox/ec2spot/fleet"
+)
+
+func runOptimizeCommand(args []string) {
+	fs := flag.NewFlagSet("optimize", flag.ExitOnError)
+	fetchPools := priceFlags(fs, "Consider")
+	capacityFlag := fs.Int("capacity", 0, "How many vCPUs the fleet needs")
+	maxShareFlag := fs.Float64("max-share", 50, "The largest percentage of capacity any one pool can provide")
+	minPoolsFlag := fs.Int("min-pools", 2, "The fewest pools to spread capacity across")
+	jsonFlag := fs.Bool("json", false, "Output the plan as JSON")
+	configureClient := clientFlags(fs)
+	fs.Parse(args)
+	configureClient()
+
+	if *capacityFlag <= 0 {
+		log.Fatal("-capacity is required")
+	}
+
+	prices, params, err := fetchPools()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	histories, err := poolHistories(prices, params)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// pools are priced at their time weighted average over the range
+	pools := []fleet.Pool{}
+	for _, h := range histories {
+		pool := h.Pool
+		pool.Price = h.Prices.TimeWeightedAverage(params.Range)
+		pools = append(pools, pool)
+	}
+
+	plan, err := fleet.Optimize(pools, fleet.OptimizeParams{
+		Capacity: *capacityFlag,
+		MaxShare: *maxShareFlag / 100,
+		MinPools: *minPoolsFlag,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		showPlan(plan, *capacityFlag)
+	}
+
+	exitIfIncomplete(params)
+}
+
+func showPlan(plan fleet.Plan, capacity int) {
+	fmt.Printf("%-15s %-15s %-12s %-25s %7s %9s %7s %10s\n",
+		"Region", "Zone", "Instance", "Product", "Share", "Instances", "vCPUs", "Hourly")
+
+	for _, a := range plan.Allocations {
+		fmt.Printf("%-15s %-15s %-12s %-25s %6.1f%% %9d %7d %10s\n",
+			a.Region, a.AvailabilityZone, a.InstanceType, a.Product, a.Share*100, a.Instances, a.VCPUs,
+			"$"+formatPrice(a.HourlyCost))
+	}
+
+	fmt.Printf("\n%d pools providing %d vCPUs (%d requested)\n", len(plan.Allocations), plan.VCPUs, capacity)
+	fmt.Printf("Blended hourly cost: $%.4f ($%.5f per vCPU)\n", plan.HourlyCost, plan.CostPerVCPU())
+}
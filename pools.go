@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fleet"
+)
+
+// poolHistories returns the price history of every instance type and availability zone with
+// prices, skipping instance types the catalog doesn't know the size of
+func poolHistories(prices *data.PriceIndex, params analysisParams) ([]fleet.History, error) {
+	histories := []fleet.History{}
+
+	for _, region := range params.Regions {
+		for _, instanceType := range params.InstanceTypes {
+			for _, product := range params.Products {
+				info, err := data.GetInstanceTypeInfo(region, instanceType, product)
+				if err != nil {
+					return nil, err
+				}
+
+				if info.VCPU == 0 {
+					log.Printf("Skipping %s, the instance catalog doesn't know how many vCPUs it has", instanceType)
+					break
+				}
+
+				for _, az := range prices.AvailabilityZones(region, instanceType, product) {
+					histories = append(histories, fleet.History{
+						Pool: fleet.Pool{
+							Region:           region,
+							InstanceType:     instanceType,
+							AvailabilityZone: az,
+							Product:          product,
+							VCPU:             info.VCPU,
+						},
+						OnDemandPrice: info.Price,
+						Prices:        prices.Series(region, instanceType, product, az),
+					})
+				}
+			}
+		}
+	}
+
+	return histories, nil
+}
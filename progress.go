@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lox/ec2spot/fetcher"
+)
+
+const progressBarWidth = 30
+
+// isTerminal returns true if f is a character device, e.g an interactive terminal
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// showProgress reports on p until the returned function is called, drawing a progress bar
+// on terminals and logging periodically otherwise
+func showProgress(p *fetcher.Progress, f *os.File) func() {
+	tty := isTerminal(f)
+	interval := time.Second * 10
+	if tty {
+		interval = time.Millisecond * 250
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if tty {
+					drawProgressBar(f, p.Snapshot())
+				} else {
+					logProgress(p.Snapshot())
+				}
+			case <-done:
+				if tty {
+					drawProgressBar(f, p.Snapshot())
+					fmt.Fprintln(f)
+				} else {
+					logProgress(p.Snapshot())
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func drawProgressBar(w io.Writer, s fetcher.ProgressSnapshot) {
+	filled := int(s.Percent() / 100 * progressBarWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	fmt.Fprintf(w, "\r[%s] %d/%d specs, %d records, %d errors, ETA %s   ",
+		bar, s.Completed, s.Total, s.Records, totalErrors(s), s.ETA.Round(time.Second))
+}
+
+func logProgress(s fetcher.ProgressSnapshot) {
+	log.Printf("progress completed=%d total=%d percent=%.1f records=%d errors=%s elapsed=%s eta=%s",
+		s.Completed, s.Total, s.Percent(), s.Records, formatErrors(s),
+		s.Elapsed.Round(time.Second), s.ETA.Round(time.Second))
+}
+
+func totalErrors(s fetcher.ProgressSnapshot) int {
+	var total int
+	for _, count := range s.Errors {
+		total += count
+	}
+	return total
+}
+
+// formatErrors formats errors per region, e.g us-east-1:2,eu-west-1:1
+func formatErrors(s fetcher.ProgressSnapshot) string {
+	regions := []string{}
+	for region, count := range s.Errors {
+		regions = append(regions, fmt.Sprintf("%s:%d", region, count))
+	}
+
+	if len(regions) == 0 {
+		return "0"
+	}
+
+	sort.Strings(regions)
+	return strings.Join(regions, ",")
+}
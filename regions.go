@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+)
+
+// regionLister returns every region that can be queried
+type regionLister func() ([]string, error)
+
+// knownRegions lists the regions enabled for the account, falling back to the regions in
+// the instance catalog when AWS can't be asked
+func knownRegions() ([]string, error) {
+	regions, err := fetcher.Regions()
+	if err != nil {
+		log.Printf("Failed to list regions, using the instance catalog instead: %v", err)
+		return data.Regions(), nil
+	}
+	return regions, nil
+}
+
+// catalogRegions lists the regions in the instance catalog
+func catalogRegions() ([]string, error) {
+	return data.Regions(), nil
+}
+
+// pricedRegions lists the regions that have prices
+func pricedRegions(prices data.SpotPriceSlice) regionLister {
+	return func() ([]string, error) {
+		seen := map[string]struct{}{}
+		regions := []string{}
+		for _, p := range prices {
+			if _, ok := seen[p.Region]; !ok {
+				seen[p.Region] = struct{}{}
+				regions = append(regions, p.Region)
+			}
+		}
+		sort.Strings(regions)
+		return regions, nil
+	}
+}
+
+// parseRegions expands a comma delimited list of regions. "all" is every region and patterns
+// like us-* match a group of regions, regions matched this way are skipped if none of the
+// instance types are offered in them. Regions that are named exactly are always kept.
+func parseRegions(regionFlag string, instanceTypes []string, list regionLister) ([]string, error) {
+	var known []string
+	regions := []string{}
+
+	for _, pattern := range strings.Split(regionFlag, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if pattern != "all" && !strings.ContainsAny(pattern, "*?[") {
+			if !containsString(regions, pattern) {
+				regions = append(regions, pattern)
+			}
+			continue
+		}
+
+		if known == nil {
+			var err error
+			if known, err = list(); err != nil {
+				return nil, err
+			}
+		}
+
+		matched := 0
+		for _, region := range known {
+			ok, err := path.Match(pattern, region)
+			if err != nil {
+				return nil, fmt.Errorf("invalid region pattern %q: %v", pattern, err)
+			}
+			if pattern != "all" && !ok {
+				continue
+			}
+
+			matched++
+			if !containsString(regions, region) && offeredInRegion(region, instanceTypes) {
+				regions = append(regions, region)
+			}
+		}
+
+		if matched == 0 {
+			return nil, fmt.Errorf("no regions match %q", pattern)
+		}
+	}
+
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("none of the instance types are offered in %s", regionFlag)
+	}
+
+	return regions, nil
+}
+
+func offeredInRegion(region string, instanceTypes []string) bool {
+	for _, instanceType := range instanceTypes {
+		if data.OfferedIn(region, instanceType) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func fakeRegions() ([]string, error) {
+	return []string{"ap-south-1", "eu-west-1", "us-east-1", "us-gov-west-1", "us-west-2"}, nil
+}
+
+func TestParseRegions(t *testing.T) {
+	for _, tc := range []struct {
+		Flag          string
+		InstanceTypes []string
+		Expected      []string
+	}{
+		{"us-east-1,eu-west-1", []string{"c4.large"}, []string{"us-east-1", "eu-west-1"}},
+		{"us-*", []string{"c4.large"}, []string{"us-east-1", "us-gov-west-1", "us-west-2"}},
+		{"eu-west-1,all", []string{"c4.large"}, []string{"eu-west-1", "ap-south-1", "us-east-1", "us-gov-west-1", "us-west-2"}},
+		// g2.2xlarge isn't offered in us-gov-west-1 in the catalog
+		{"us-*", []string{"g2.2xlarge"}, []string{"us-east-1", "us-west-2"}},
+	} {
+		regions, err := parseRegions(tc.Flag, tc.InstanceTypes, fakeRegions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(regions, tc.Expected) {
+			t.Fatalf("Expected %v for %q, got %v", tc.Expected, tc.Flag, regions)
+		}
+	}
+
+	if _, err := parseRegions("mars-*", []string{"c4.large"}, fakeRegions); err == nil {
+		t.Fatalf("Expected an error for a pattern matching no regions")
+	}
+}
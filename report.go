@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+)
+
+type instanceReport struct {
+	Region            string                   `json:"region"`
+	InstanceType      string                   `json:"instance_type"`
+	Product           string                   `json:"product"`
+	OnDemandPrice     float64                  `json:"on_demand_price,omitempty"`
+	AvailabilityZones []availabilityZoneReport `json:"availability_zones"`
+	Estimate          *costEstimate            `json:"estimate,omitempty"`
+	Missing           []missingReport          `json:"missing,omitempty"`
+}
+
+type availabilityZoneReport struct {
+	AvailabilityZone string         `json:"availability_zone"`
+	Stability        data.Stability `json:"stability"`
+	Spikes           []data.Spike   `json:"spikes"`
+}
+
+// missingReport is a chunk of history that failed to fetch
+type missingReport struct {
+	AvailabilityZone string    `json:"availability_zone,omitempty"`
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	Error            string    `json:"error"`
+}
+
+// missingReports lists the failed chunks for a region, instance type and product, naming
+// zones by id if ids is set
+func missingReports(failures *fetcher.Failures, region, instanceType, product string, ids map[string]string) []missingReport {
+	if failures == nil {
+		return nil
+	}
+
+	missing := []missingReport{}
+	for _, f := range failures.List() {
+		if f.Spec.Region == region && containsString(f.Spec.InstanceTypes, instanceType) &&
+			containsString(f.Spec.ProductDescriptions, product) {
+			az := f.Spec.AvailabilityZone
+			if id, ok := ids[az]; ok {
+				az = id
+			}
+
+			missing = append(missing, missingReport{
+				AvailabilityZone: az,
+				Start:            f.Spec.Start,
+				End:              f.Spec.End,
+				Error:            f.Err.Error(),
+			})
+		}
+	}
+
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].Start.Before(missing[j].Start)
+	})
+
+	return missing
+}
+
+func showMissing(missing []missingReport) {
+	if len(missing) == 0 {
+		return
+	}
+
+	fmt.Printf("\nMissing data (%d chunks failed to fetch, results are incomplete)\n", len(missing))
+	for _, m := range missing {
+		az := m.AvailabilityZone
+		if az == "" {
+			az = "all zones"
+		}
+		fmt.Printf("  %s %s - %s: %s\n", az, m.Start.Format(time.RFC3339), m.End.Format(time.RFC3339), m.Error)
+	}
+}
+
+func writeJSONReport(w io.Writer, reports []instanceReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reports)
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+	"github.com/lox/ec2spot/timerange"
+)
+
+func runServeCommand(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listenFlag := fs.String("listen", ":8080", "Address to listen on")
+	concurrencyFlag := fs.Int("concurrency", 10, "How many concurrent AWS requests to make per analysis")
+	maxAnalysesFlag := fs.Int("max-analyses", 4, "How many analyses can run at once")
+	cacheTTLFlag := fs.Duration("cache-ttl", time.Minute*10, "How long to cache analysis results for")
+	timeoutFlag := fs.Duration("analysis-timeout", time.Minute*5, "How long an analysis can run before it's abandoned")
+	thresholdFlag := fs.Float64("stability-threshold", 80, "Percentage of on-demand price that counts as expensive in stability scores, unless a request sets stability_threshold")
+	configureClient := clientFlags(fs)
+	fs.Parse(args)
+	configureClient()
+
+	if *concurrencyFlag < 1 {
+		log.Fatal("-concurrency must be at least 1")
+	}
+
+	if *maxAnalysesFlag < 1 {
+		log.Fatal("-max-analyses must be at least 1")
+	}
+
+	srv := newAPIServer(*concurrencyFlag, *maxAnalysesFlag, *cacheTTLFlag)
+	srv.timeout = *timeoutFlag
+	srv.stabilityThreshold = *thresholdFlag
+
+	log.Printf("Listening on %s", *listenFlag)
+	log.Fatal(http.ListenAndServe(*listenFlag, srv))
+}
+
+type analysisFunc func(ctx context.Context, params analysisParams) (*data.PriceIndex, error)
+
+// apiResult is the prices fetched for a request and the range they cover
+type apiResult struct {
+	Prices data.SpotPriceSlice
+	Range  timerange.Range
+}
+
+type cacheEntry struct {
+	apiResult
+	Expires time.Time
+}
+
+// pendingAnalysis is an analysis that concurrent requests for the same prices wait on. It's
+// cancelled once no requests are waiting.
+type pendingAnalysis struct {
+	done    chan struct{}
+	result  apiResult
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// maxCacheEntries bounds the cache, entries closest to expiring are evicted first
+const maxCacheEntries = 1000
+
+// apiServer exposes analyses over HTTP, caching results and limiting concurrent analyses
+type apiServer struct {
+	mux         *http.ServeMux
+	analyze     analysisFunc
+	zones       zoneLister
+	concurrency int
+	ttl         time.Duration
+	timeout     time.Duration
+	limit       chan struct{}
+
+	// stabilityThreshold is the percentage of on-demand used unless a request sets one
+	stabilityThreshold float64
+
+	cacheLock sync.Mutex
+	cache     map[string]cacheEntry
+	pending   map[string]*pendingAnalysis
+}
+
+func newAPIServer(concurrency, maxAnalyses int, ttl time.Duration) *apiServer {
+	srv := &apiServer{
+		mux:         http.NewServeMux(),
+		analyze:     runAnalysis,
+		zones:       fetcher.AvailabilityZones,
+		concurrency: concurrency,
+		ttl:         ttl,
+		timeout:     time.Minute * 5,
+		limit:       make(chan struct{}, maxAnalyses),
+		cache:       map[string]cacheEntry{},
+		pending:     map[string]*pendingAnalysis{},
+
+		stabilityThreshold: 80,
+	}
+
+	srv.mux.HandleFunc("/prices", srv.handlePrices)
+	srv.mux.HandleFunc("/summary", srv.handleSummary)
+	srv.mux.HandleFunc("/histogram", srv.handleHistogram)
+	srv.mux.HandleFunc("/estimate", srv.handleEstimate)
+
+	return srv
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+type apiRequest struct {
+	Region       string
+	InstanceType string
+	Product      string
+	AZs          []string
+	Days         int
+	ZoneIDs      bool
+	// StabilityThreshold is a percentage of on-demand, zero uses the server's default
+	StabilityThreshold float64
+}
+
+func (r apiRequest) cacheKey() string {
+	return strings.Join([]string{
+		r.Region, r.InstanceType, r.Product, strings.Join(r.AZs, ","), strconv.Itoa(r.Days), strconv.FormatBool(r.ZoneIDs),
+	}, "|")
+}
+
+func parseAPIRequest(r *http.Request, zones zoneLister) (apiRequest, error) {
+	q := r.URL.Query()
+	req := apiRequest{
+		Region:       q.Get("region"),
+		InstanceType: q.Get("instance"),
+		Product:      q.Get("product"),
+		Days:         7,
+		ZoneIDs:      q.Get("az_ids") == "true",
+	}
+
+	if req.Region == "" || req.InstanceType == "" {
+		return req, fmt.Errorf("region and instance are required")
+	}
+
+	if req.Product == "" {
+		req.Product = "Linux/UNIX (Amazon VPC)"
+	}
+
+	if days := q.Get("days"); days != "" {
+		d, err := strconv.Atoi(days)
+		if err != nil || d < 1 || d > 90 {
+			return req, fmt.Errorf("days must be between 1 and 90")
+		}
+		req.Days = d
+	}
+
+	if threshold := q.Get("stability_threshold"); threshold != "" {
+		v, err := strconv.ParseFloat(threshold, 64)
+		if err != nil || v <= 0 || v > 100 {
+			return req, fmt.Errorf("stability_threshold must be a percentage between 0 and 100")
+		}
+		req.StabilityThreshold = v
+	}
+
+	azs, err := parseAvailabilityZones([]string{req.Region}, q.Get("azs"), zones)
+	if err != nil {
+		return req, err
+	}
+
+	req.AZs = azs
+	return req, nil
+}
+
+// prices returns cached prices for a request, or waits for an analysis to fetch them.
+// Concurrent requests for the same prices share one analysis.
+func (s *apiServer) prices(ctx context.Context, req apiRequest) (apiResult, error) {
+	key := req.cacheKey()
+
+	s.cacheLock.Lock()
+	if entry, ok := s.cache[key]; ok && time.Now().Before(entry.Expires) {
+		s.cacheLock.Unlock()
+		return entry.apiResult, nil
+	}
+
+	pending, ok := s.pending[key]
+	if !ok {
+		analysisCtx, cancel := context.WithTimeout(context.Background(), s.timeout)
+		pending = &pendingAnalysis{done: make(chan struct{}), cancel: cancel}
+		s.pending[key] = pending
+		go s.runPending(analysisCtx, key, req, pending)
+	}
+	pending.waiters++
+	s.cacheLock.Unlock()
+
+	select {
+	case <-pending.done:
+		return pending.result, pending.err
+	case <-ctx.Done():
+		s.cacheLock.Lock()
+		pending.waiters--
+		if pending.waiters == 0 {
+			pending.cancel()
+			// later requests start a fresh analysis rather than joining a cancelled one
+			if s.pending[key] == pending {
+				delete(s.pending, key)
+			}
+		}
+		s.cacheLock.Unlock()
+		return apiResult{}, ctx.Err()
+	}
+}
+
+// runPending runs an analysis for the requests waiting on it, until they all go away or
+// the analysis times out
+func (s *apiServer) runPending(ctx context.Context, key string, req apiRequest, pending *pendingAnalysis) {
+	defer pending.cancel()
+	pending.result, pending.err = s.fetchPrices(ctx, req)
+
+	s.cacheLock.Lock()
+	if s.pending[key] == pending {
+		delete(s.pending, key)
+	}
+	if pending.err == nil {
+		s.store(key, pending.result)
+	}
+	s.cacheLock.Unlock()
+
+	close(pending.done)
+}
+
+// fetchPrices runs an analysis for a request once a slot is free
+func (s *apiServer) fetchPrices(ctx context.Context, req apiRequest) (apiResult, error) {
+	select {
+	case s.limit <- struct{}{}:
+	case <-ctx.Done():
+		return apiResult{}, ctx.Err()
+	}
+	defer func() { <-s.limit }()
+
+	tr := timerange.DaysAgo(time.Now(), req.Days)
+
+	index, err := s.analyze(ctx, analysisParams{
+		InstanceTypes:     []string{req.InstanceType},
+		Regions:           []string{req.Region},
+		AvailabilityZones: req.AZs,
+		Products:          []string{req.Product},
+		Days:              req.Days,
+		Range:             tr,
+		Concurrency:       s.concurrency,
+	})
+	if err != nil {
+		return apiResult{}, err
+	}
+
+	prices := index.Select(req.Region, req.InstanceType, req.Product)
+
+	if req.ZoneIDs {
+		ids, err := zoneIDs([]string{req.Region}, s.zones)
+		if err != nil {
+			return apiResult{}, err
+		}
+		prices = prices.WithZoneIDs(ids)
+	}
+
+	return apiResult{Prices: prices, Range: tr}, nil
+}
+
+// store caches a result, dropping expired entries and evicting those closest to expiring
+// when the cache is full. The cache lock must be held.
+func (s *apiServer) store(key string, result apiResult) {
+	now := time.Now()
+	for k, entry := range s.cache {
+		if !now.Before(entry.Expires) {
+			delete(s.cache, k)
+		}
+	}
+
+	for len(s.cache) >= maxCacheEntries {
+		var oldest string
+		for k, entry := range s.cache {
+			if oldest == "" || entry.Expires.Before(s.cache[oldest].Expires) {
+				oldest = k
+			}
+		}
+		delete(s.cache, oldest)
+	}
+
+	s.cache[key] = cacheEntry{apiResult: result, Expires: now.Add(s.ttl)}
+}
+
+// handle parses the request and fetches prices before calling f to build the response
+func (s *apiServer) handle(w http.ResponseWriter, r *http.Request, f func(apiRequest, apiResult) (interface{}, error)) {
+	req, err := parseAPIRequest(r, s.zones)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := s.prices(r.Context(), req)
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	resp, err := f(req, result)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type apiPrice struct {
+	AvailabilityZone string    `json:"availability_zone"`
+	ZoneID           string    `json:"availability_zone_id,omitempty"`
+	Price            float64   `json:"price"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+func (s *apiServer) handlePrices(w http.ResponseWriter, r *http.Request) {
+	s.handle(w, r, func(req apiRequest, result apiResult) (interface{}, error) {
+		prices := []apiPrice{}
+		for _, p := range result.Prices {
+			prices = append(prices, apiPrice{
+				AvailabilityZone: p.AvailabilityZone,
+				ZoneID:           p.AvailabilityZoneID,
+				Price:            p.Price,
+				Timestamp:        p.Timestamp,
+			})
+		}
+		return prices, nil
+	})
+}
+
+type apiSummary struct {
+	AvailabilityZone string          `json:"availability_zone,omitempty"`
+	Count            int             `json:"count"`
+	Min              float64         `json:"min"`
+	Max              float64         `json:"max"`
+	Average          float64         `json:"average"`
+	Stability        *data.Stability `json:"stability,omitempty"`
+}
+
+func summarize(prices data.SpotPriceSlice) apiSummary {
+	if len(prices) == 0 {
+		return apiSummary{}
+	}
+	return apiSummary{
+		Count:   len(prices),
+		Min:     prices.Min(),
+		Max:     prices.Max(),
+		Average: prices.Average(),
+	}
+}
+
+func (s *apiServer) handleSummary(w http.ResponseWriter, r *http.Request) {
+	s.handle(w, r, func(req apiRequest, result apiResult) (interface{}, error) {
+		prices := result.Prices
+		info, err := data.GetInstanceTypeInfo(req.Region, req.InstanceType, req.Product)
+		if err != nil {
+			return nil, err
+		}
+
+		tr := result.Range
+		zones := []apiSummary{}
+
+		threshold := s.stabilityThreshold
+		if req.StabilityThreshold > 0 {
+			threshold = req.StabilityThreshold
+		}
+
+		for _, az := range prices.AvailabilityZones() {
+			byAz := prices.ByAvailabilityZone(az)
+			stability := byAz.Stability(tr, info.Price, threshold/100)
+
+			summary := summarize(byAz)
+			summary.AvailabilityZone = az
+			summary.Stability = &stability
+			zones = append(zones, summary)
+		}
+
+		summary := map[string]interface{}{
+			"all":                summarize(prices),
+			"availability_zones": zones,
+		}
+		// the catalog doesn't have on-demand prices for every product
+		if info.Price > 0 {
+			summary["on_demand_price"] = info.Price
+		}
+		return summary, nil
+	})
+}
+
+type apiBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+func (s *apiServer) handleHistogram(w http.ResponseWriter, r *http.Request) {
+	s.handle(w, r, func(req apiRequest, result apiResult) (interface{}, error) {
+		bins := 3
+		if b := r.URL.Query().Get("bins"); b != "" {
+			var err error
+			if bins, err = strconv.Atoi(b); err != nil || bins < 1 {
+				return nil, fmt.Errorf("bins must be a positive number")
+			}
+		}
+
+		buckets := []apiBucket{}
+		for _, b := range priceHistogram(result.Prices, bins).Buckets {
+			buckets = append(buckets, apiBucket{Min: b.Min, Max: b.Max, Count: b.Count})
+		}
+		return buckets, nil
+	})
+}
+
+func (s *apiServer) handleEstimate(w http.ResponseWriter, r *http.Request) {
+	s.handle(w, r, func(req apiRequest, result apiResult) (interface{}, error) {
+		var maxBid float64
+		if mb := r.URL.Query().Get("max-bid"); mb != "" {
+			var err error
+			if maxBid, err = strconv.ParseFloat(mb, 64); err != nil {
+				return nil, fmt.Errorf("max-bid must be a number")
+			}
+		}
+
+		info, err := data.GetInstanceTypeInfo(req.Region, req.InstanceType, req.Product)
+		if err != nil {
+			return nil, err
+		}
+
+		return calculateCost(costEstimateParams{
+			Days:         req.Days,
+			Range:        result.Range,
+			InstanceInfo: info,
+			Prices:       result.Prices,
+			MaxBid:       maxBid,
+		}), nil
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
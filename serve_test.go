@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+)
+
+func TestAPIServerCachesAnalyses(t *testing.T) {
+	var calls int
+
+	srv := newAPIServer(1, 1, time.Minute)
+	srv.analyze = func(ctx context.Context, params analysisParams) (*data.PriceIndex, error) {
+		calls++
+		return data.IndexPrices(data.SpotPriceSlice{
+			{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: "Linux/UNIX (Amazon VPC)", AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: time.Now()},
+			{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: "Linux/UNIX (Amazon VPC)", AvailabilityZone: "us-east-1b", Price: 0.3, Timestamp: time.Now()},
+		}), nil
+	}
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/prices?region=us-east-1&instance=c4.large", nil))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var prices []apiPrice
+		if err := json.NewDecoder(rr.Body).Decode(&prices); err != nil {
+			t.Fatal(err)
+		}
+
+		if l := len(prices); l != 2 {
+			t.Fatalf("Expected 2 prices, got %d", l)
+		}
+	}
+
+	if calls != 1 {
+		t.Fatalf("Expected 1 analysis, got %d", calls)
+	}
+}
+
+func TestAPIServerRequiresRegionAndInstance(t *testing.T) {
+	srv := newAPIServer(1, 1, time.Minute)
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/summary?region=us-east-1", nil))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", rr.Code)
+	}
+}
+
+func TestAPIServerSharesConcurrentAnalyses(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+
+	srv := newAPIServer(1, 2, time.Minute)
+	srv.analyze = func(ctx context.Context, params analysisParams) (*data.PriceIndex, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return data.NewPriceIndex(), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := httptest.NewRecorder()
+			srv.ServeHTTP(rr, httptest.NewRequest("GET", "/prices?region=us-east-1&instance=c4.large", nil))
+			if rr.Code != http.StatusOK {
+				t.Errorf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+		}()
+	}
+
+	// wait for every request to be waiting on the analysis
+	for {
+		srv.cacheLock.Lock()
+		pending := len(srv.pending)
+		srv.cacheLock.Unlock()
+		if pending == 1 && atomic.LoadInt32(&calls) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(time.Millisecond * 10)
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected 1 analysis, got %d", n)
+	}
+}
+
+func TestAPIServerDropsExpiredEntries(t *testing.T) {
+	srv := newAPIServer(1, 1, time.Millisecond)
+	srv.analyze = func(ctx context.Context, params analysisParams) (*data.PriceIndex, error) {
+		return data.NewPriceIndex(), nil
+	}
+
+	for _, instance := range []string{"c4.large", "c4.xlarge"} {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest("GET", "/prices?region=us-east-1&instance="+instance, nil))
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	srv.cacheLock.Lock()
+	defer srv.cacheLock.Unlock()
+
+	if l := len(srv.cache); l != 1 {
+		t.Fatalf("Expected the expired entry to be dropped, got %d entries", l)
+	}
+}
+
+func TestAPIServerCancelsAbandonedAnalyses(t *testing.T) {
+	cancelled := make(chan struct{})
+
+	srv := newAPIServer(1, 1, time.Minute)
+	srv.analyze = func(ctx context.Context, params analysisParams) (*data.PriceIndex, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/prices?region=us-east-1&instance=c4.large", nil).WithContext(ctx))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the analysis to be cancelled once the request went away")
+	}
+}
+
+func TestAPIServerTimesOutAnalyses(t *testing.T) {
+	srv := newAPIServer(1, 1, time.Minute)
+	srv.timeout = time.Millisecond * 10
+	srv.analyze = func(ctx context.Context, params analysisParams) (*data.PriceIndex, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, httptest.NewRequest("GET", "/prices?region=us-east-1&instance=c4.large", nil))
+
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("Expected 502, got %d", rr.Code)
+	}
+}
+
+func TestParseAPIRequestStabilityThreshold(t *testing.T) {
+	req, err := parseAPIRequest(httptest.NewRequest("GET", "/summary?region=us-east-1&instance=c4.large&stability_threshold=50", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.StabilityThreshold != 50 {
+		t.Fatalf("Expected a threshold of 50, got %v", req.StabilityThreshold)
+	}
+
+	if _, err := parseAPIRequest(httptest.NewRequest("GET", "/summary?region=us-east-1&instance=c4.large&stability_threshold=150", nil), nil); err == nil {
+		t.Fatal("Expected an error for a threshold over 100")
+	}
+}
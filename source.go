@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+	"github.com/lox/ec2spot/timerange"
+)
+
+// priceFlags registers the flags that choose which prices to analyze and where they come
+// from, AWS or offline from imported files or a store. It returns a function that loads the
+// prices once the flags are parsed.
+func priceFlags(fs *flag.FlagSet, usage string) func() (*data.PriceIndex, analysisParams, error) {
+	daysFlag := fs.Int("days", 7, "How many days to go back")
+	parseInstanceTypes := instanceFlags(fs, usage+" a particular instance type")
+	parseProducts := productFlags(fs, usage)
+	regionFlag := fs.String("region", "us-east-1", usage+" a particular region, multiple comma delimited, a group like us-* or all")
+	azsFlag := fs.String("azs", "", "Only include specific availability zones, as letters applied to each region (e.g a,b,c), names or ids")
+	concurrencyFlag := fs.Int("concurrency", 10, "How many concurrent AWS requests to make")
+	continueFlag := fs.Bool("continue-on-error", false, "Report on whatever could be fetched if some requests fail, exiting with status 3")
+	recordFlag := fs.String("record", "", "Save every AWS response into this directory")
+	replayFlag := fs.String("replay", "", "Replay AWS responses saved with -record from this directory")
+	importFlag := fs.String("import", "", "Read price history from .json or .csv files instead of AWS, comma delimited")
+	azIDsFlag := fs.Bool("az-ids", false, "Group availability zones by zone id (e.g use1-az4), which is the same physical zone in every account")
+	zoneMapFlag := fs.String("zone-map", "", "Read zone names and ids from the output of `aws ec2 describe-availability-zones` when AWS can't be asked")
+	storeFlag := fs.String("store", "", "Read price history from a local store populated by the sync command instead of AWS")
+
+	return func() (*data.PriceIndex, analysisParams, error) {
+		now := time.Now()
+
+		if *recordFlag != "" {
+			if err := fetcher.Record(*recordFlag, now); err != nil {
+				return nil, analysisParams{}, err
+			}
+		}
+
+		if *replayFlag != "" {
+			var err error
+			if now, err = fetcher.Replay(*replayFlag); err != nil {
+				return nil, analysisParams{}, err
+			}
+		}
+
+		products := parseProducts()
+
+		var imported data.SpotPriceSlice
+		if *importFlag != "" {
+			var err error
+			if imported, err = importFiles(strings.Split(*importFlag, ","), products[0]); err != nil {
+				return nil, analysisParams{}, err
+			}
+			// analyze the days leading up to the end of the imported history
+			now = imported.Latest()
+		}
+
+		instanceTypes, err := parseInstanceTypes()
+		if err != nil {
+			return nil, analysisParams{}, err
+		}
+
+		listRegions := regionLister(knownRegions)
+		if imported != nil {
+			listRegions = pricedRegions(imported)
+		} else if *storeFlag != "" {
+			listRegions = catalogRegions
+		}
+
+		regions, err := parseRegions(*regionFlag, instanceTypes, listRegions)
+		if err != nil {
+			return nil, analysisParams{}, err
+		}
+
+		params := analysisParams{
+			InstanceTypes: instanceTypes,
+			Regions:       regions,
+			Concurrency:   *concurrencyFlag,
+			Products:      products,
+			Days:          *daysFlag,
+			Range:         timerange.DaysAgo(now, *daysFlag),
+		}
+
+		// imported or stored prices are analyzed without talking to AWS
+		offline := imported
+		if *storeFlag != "" {
+			if offline, err = queryStore(*storeFlag, params); err != nil {
+				return nil, analysisParams{}, err
+			}
+		}
+
+		listZones := zoneLister(fetcher.AvailabilityZones)
+		if offline != nil {
+			listZones = pricedZones(offline)
+		}
+
+		if *zoneMapFlag != "" {
+			zoneMap, err := loadZoneMap(*zoneMapFlag)
+			if err != nil {
+				return nil, analysisParams{}, err
+			}
+			listZones = withFallback(listZones, zoneMap)
+		}
+
+		if params.AvailabilityZones, err = parseAvailabilityZones(regions, *azsFlag, listZones); err != nil {
+			return nil, analysisParams{}, err
+		}
+
+		if *azIDsFlag {
+			if params.ZoneIDs, err = zoneIDs(regions, listZones); err != nil {
+				return nil, analysisParams{}, err
+			}
+		}
+
+		if offline != nil {
+			filtered := filterPrices(offline, params)
+			if params.ZoneIDs != nil {
+				filtered = filtered.WithZoneIDs(params.ZoneIDs)
+			}
+			return data.IndexPrices(filtered), params, nil
+		}
+
+		params.Progress = fetcher.NewProgress()
+		if *continueFlag {
+			params.Failures = fetcher.NewFailures()
+		}
+
+		stopProgress := showProgress(params.Progress, os.Stderr)
+		prices, err := runAnalysis(context.Background(), params)
+		stopProgress()
+
+		return prices, params, err
+	}
+}
+
+// exitIfIncomplete exits with exitPartialResults if -continue-on-error skipped failed requests
+func exitIfIncomplete(params analysisParams) {
+	if params.Failures != nil && params.Failures.Len() > 0 {
+		log.Printf("%d requests failed, results are incomplete", params.Failures.Len())
+		os.Exit(exitPartialResults)
+	}
+}
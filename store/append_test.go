@@ -0,0 +1,52 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+)
+
+func TestAppendCanBeRetriedAfterAFailedWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ec2spot-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(filepath.Join(dir, "ec2spot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	prices := data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: time.Now()},
+	}
+
+	// writes to a read only handle fail
+	f := s.f
+	s.f, err = os.Open(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Append(prices); err == nil {
+		t.Fatal("Expected the append to fail")
+	}
+
+	s.f.Close()
+	s.f = f
+
+	added, err := s.Append(prices)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added != 1 {
+		t.Fatalf("Expected the retry to add 1 price, got %d", added)
+	}
+}
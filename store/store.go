@@ -0,0 +1,285 @@
+// Package store is an append-only, single file database of spot price history that
+// outlives the 90 days of history that AWS keeps
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/timerange"
+)
+
+// record is a single line in the store file
+type record struct {
+	Region           string    `json:"r"`
+	InstanceType     string    `json:"i"`
+	AvailabilityZone string    `json:"z"`
+	Product          string    `json:"p"`
+	Price            float64   `json:"v"`
+	Timestamp        time.Time `json:"t"`
+}
+
+func (r record) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d", r.Region, r.InstanceType, r.AvailabilityZone, r.Product, r.Timestamp.UnixNano())
+}
+
+func seriesKey(region, instanceType, product string) string {
+	return region + "|" + instanceType + "|" + product
+}
+
+// Store is a file of newline delimited price records that is only ever appended to
+type Store struct {
+	sync.Mutex
+	f        *os.File
+	readOnly bool
+	keys     map[string]struct{}
+	latest   map[string]time.Time
+}
+
+// Open opens or creates a store, indexing existing records so duplicates aren't appended.
+// A partially written final record, e.g from a crash, is discarded. The file is locked until
+// the store is closed, so another process can't open it at the same time.
+func Open(path string) (*Store, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s is in use by another process: %v", path, err)
+	}
+
+	s := &Store{
+		f:      f,
+		keys:   map[string]struct{}{},
+		latest: map[string]time.Time{},
+	}
+
+	var good int64
+	err = s.scan(func(r record, end int64) {
+		s.index(r)
+		good = end
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// OpenReadOnly opens an existing store for querying without locking it, so reports can run
+// while a sync appends to it. Records still being written are ignored.
+func OpenReadOnly(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		f:        f,
+		readOnly: true,
+		keys:     map[string]struct{}{},
+		latest:   map[string]time.Time{},
+	}, nil
+}
+
+// Close closes the underlying file, releasing its lock
+func (s *Store) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.f.Close()
+}
+
+func (s *Store) index(r record) {
+	s.keys[r.key()] = struct{}{}
+
+	series := seriesKey(r.Region, r.InstanceType, r.Product)
+	if r.Timestamp.After(s.latest[series]) {
+		s.latest[series] = r.Timestamp
+	}
+}
+
+// scan calls f with every complete record in the file and the offset after it
+func (s *Store) scan(f func(r record, end int64)) error {
+	rd, err := os.Open(s.f.Name())
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+
+	br := bufio.NewReader(rd)
+	var offset int64
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			// anything without a trailing newline is an incomplete write
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var r record
+		if err := json.Unmarshal(line, &r); err != nil {
+			return fmt.Errorf("corrupt record at offset %d: %v", offset-int64(len(line)), err)
+		}
+
+		f(r, offset)
+	}
+}
+
+// Latest returns the timestamp of the newest record for a region, instance type and product
+func (s *Store) Latest(region, instanceType, product string) time.Time {
+	s.Lock()
+	defer s.Unlock()
+	return s.latest[seriesKey(region, instanceType, product)]
+}
+
+// Append writes any prices that aren't already in the store, returning how many were new
+func (s *Store) Append(prices data.SpotPriceSlice) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.readOnly {
+		return 0, fmt.Errorf("%s was opened read only", s.f.Name())
+	}
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	added := []record{}
+	seen := map[string]struct{}{}
+
+	for _, p := range prices {
+		r := record{
+			Region:           p.Region,
+			InstanceType:     p.InstanceType,
+			AvailabilityZone: p.AvailabilityZone,
+			Product:          p.ProductDescription,
+			Price:            p.Price,
+			Timestamp:        p.Timestamp.UTC(),
+		}
+
+		if _, exists := s.keys[r.key()]; exists {
+			continue
+		}
+		if _, dup := seen[r.key()]; dup {
+			continue
+		}
+
+		if err := enc.Encode(r); err != nil {
+			return 0, err
+		}
+
+		seen[r.key()] = struct{}{}
+		added = append(added, r)
+	}
+
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	offset, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.write(buf.Bytes()); err != nil {
+		// drop anything partially written so a retry appends cleanly
+		if terr := s.f.Truncate(offset); terr == nil {
+			s.f.Seek(offset, io.SeekStart)
+		}
+		return 0, err
+	}
+
+	// only index once the records are safely on disk, so a failed append can be retried
+	for _, r := range added {
+		s.index(r)
+	}
+
+	return len(added), nil
+}
+
+func (s *Store) write(b []byte) error {
+	if _, err := s.f.Write(b); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+// Query describes which prices to read from the store, empty fields match everything
+type Query struct {
+	Regions           []string
+	InstanceTypes     []string
+	AvailabilityZones []string
+	Products          []string
+	Range             timerange.Range
+}
+
+func (q Query) matches(r record) bool {
+	if !q.Range[0].IsZero() && !q.Range.Contains(r.Timestamp) {
+		return false
+	}
+
+	return contains(q.Regions, r.Region) &&
+		contains(q.Products, r.Product) &&
+		contains(q.InstanceTypes, r.InstanceType) &&
+		contains(q.AvailabilityZones, r.AvailabilityZone)
+}
+
+// Query reads the prices that match q
+func (s *Store) Query(q Query) (data.SpotPriceSlice, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	prices := data.SpotPriceSlice{}
+	err := s.scan(func(r record, end int64) {
+		if q.matches(r) {
+			prices = append(prices, data.SpotPrice{
+				Region:             r.Region,
+				InstanceType:       r.InstanceType,
+				ProductDescription: r.Product,
+				AvailabilityZone:   r.AvailabilityZone,
+				Price:              r.Price,
+				Timestamp:          r.Timestamp,
+			})
+		}
+	})
+
+	return prices, err
+}
+
+func contains(values []string, v string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package store_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/store"
+	"github.com/lox/ec2spot/timerange"
+)
+
+const product = "Linux/UNIX (Amazon VPC)"
+
+func tempStore(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "ec2spot-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "ec2spot.db"), func() { os.RemoveAll(dir) }
+}
+
+func TestStoreAppendDeduplicates(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	t1 := time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC)
+	prices := data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: product, AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: t1},
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: product, AvailabilityZone: "us-east-1a", Price: 0.2, Timestamp: t1.Add(time.Hour)},
+	}
+
+	st, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added, err := st.Append(prices); err != nil || added != 2 {
+		t.Fatalf("Expected 2 added, got %d (%v)", added, err)
+	}
+	st.Close()
+
+	// reopen to check the index is rebuilt from the file
+	st, err = store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	prices = append(prices, data.SpotPrice{
+		Region: "us-east-1", InstanceType: "c4.large", ProductDescription: product, AvailabilityZone: "us-east-1b", Price: 0.3, Timestamp: t1,
+	})
+
+	if added, err := st.Append(prices); err != nil || added != 1 {
+		t.Fatalf("Expected 1 added, got %d (%v)", added, err)
+	}
+
+	if latest := st.Latest("us-east-1", "c4.large", product); !latest.Equal(t1.Add(time.Hour)) {
+		t.Fatalf("Expected latest of %v, got %v", t1.Add(time.Hour), latest)
+	}
+
+	result, err := st.Query(store.Query{
+		AvailabilityZones: []string{"us-east-1a"},
+		Products:          []string{product},
+		Range:             timerange.Range{t1, t1.AddDate(1, 0, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(result); l != 2 {
+		t.Fatalf("Expected 2 prices, got %d", l)
+	}
+}
+
+func TestStoreDiscardsPartialWrites(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	st, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Append(data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: product, AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: time.Now()},
+	})
+	st.Close()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"r":"us-east-1","i":"c4.la`)
+	f.Close()
+
+	st, err = store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	result, err := st.Query(store.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(result); l != 1 {
+		t.Fatalf("Expected 1 price, got %d", l)
+	}
+}
+
+func TestStoreIsLockedWhileOpen(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	st, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Open(path); err == nil {
+		t.Fatal("Expected an error opening a store that is already open")
+	}
+
+	st.Close()
+
+	st, err = store.Open(path)
+	if err != nil {
+		t.Fatalf("Expected the store to open once closed, got %v", err)
+	}
+	st.Close()
+}
+
+func TestStoreCanBeReadWhileOpen(t *testing.T) {
+	path, cleanup := tempStore(t)
+	defer cleanup()
+
+	st, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	st.Append(data.SpotPriceSlice{
+		{Region: "us-east-1", InstanceType: "c4.large", ProductDescription: product, AvailabilityZone: "us-east-1a", Price: 0.1, Timestamp: time.Now()},
+	})
+
+	ro, err := store.OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+
+	result, err := ro.Query(store.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := len(result); l != 1 {
+		t.Fatalf("Expected 1 price, got %d", l)
+	}
+
+	if _, err := ro.Append(result); err == nil {
+		t.Fatal("Expected appending to a read only store to fail")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/lox/ec2spot/data"
+)
+
+type maxPriceReport struct {
+	Region            string                             `json:"region"`
+	InstanceType      string                             `json:"instance_type"`
+	Product           string                             `json:"product"`
+	OnDemandPrice     float64                            `json:"on_demand_price,omitempty"`
+	AvailabilityZones map[string]data.MaxPriceSuggestion `json:"availability_zones"`
+	AllZones          data.MaxPriceSuggestion            `json:"all_zones"`
+	Estimate          costEstimate                       `json:"estimate"`
+}
+
+func runSuggestMaxPriceCommand(args []string) {
+	fs := flag.NewFlagSet("suggest-max-price", flag.ExitOnError)
+	fetchPools := priceFlags(fs, "Consider")
+	availabilityFlag := fs.Float64("availability", 99, "Percentage of hours an instance should have kept running")
+	jsonFlag := fs.Bool("json", false, "Output the suggestions as JSON")
+	configureClient := clientFlags(fs)
+	fs.Parse(args)
+	configureClient()
+
+	if *availabilityFlag <= 0 || *availabilityFlag > 100 {
+		log.Fatal("-availability must be a percentage between 0 and 100")
+	}
+
+	prices, params, err := fetchPools()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reports := []maxPriceReport{}
+	for _, region := range params.Regions {
+		for _, instanceType := range params.InstanceTypes {
+			for _, product := range params.Products {
+				azs := prices.AvailabilityZones(region, instanceType, product)
+				if len(azs) == 0 {
+					continue
+				}
+
+				info, err := data.GetInstanceTypeInfo(region, instanceType, product)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				report := maxPriceReport{
+					Region:            region,
+					InstanceType:      instanceType,
+					Product:           product,
+					OnDemandPrice:     info.Price,
+					AvailabilityZones: map[string]data.MaxPriceSuggestion{},
+				}
+
+				zones := []data.SpotPriceSlice{}
+				for _, az := range azs {
+					series := prices.Series(region, instanceType, product, az)
+					zones = append(zones, series)
+					report.AvailabilityZones[az] = data.SuggestMaxPrice(
+						[]data.SpotPriceSlice{series}, params.Range, *availabilityFlag/100)
+				}
+
+				report.AllZones = data.SuggestMaxPrice(zones, params.Range, *availabilityFlag/100)
+				report.Estimate = calculateCost(costEstimateParams{
+					Days:         params.Days,
+					Range:        params.Range,
+					InstanceInfo: info,
+					Prices:       prices.Select(region, instanceType, product),
+					MaxBid:       report.AllZones.MaxPrice,
+				})
+
+				reports = append(reports, report)
+
+				if !*jsonFlag {
+					showMaxPriceReport(report, azs, *availabilityFlag)
+				}
+			}
+		}
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	exitIfIncomplete(params)
+}
+
+func showMaxPriceReport(report maxPriceReport, azs []string, availability float64) {
+	fmt.Printf("%-20s%s\n", "Region:", report.Region)
+	fmt.Printf("%-20s%s\n", "Instance Type:", report.InstanceType)
+	fmt.Printf("%-20s%s\n", "Product:", report.Product)
+	showOnDemandPrice(report.OnDemandPrice, report.Product)
+
+	fmt.Printf("\nLowest max price running at least %.4g%% of hours\n", availability)
+	for _, az := range azs {
+		showMaxPriceSuggestion(az, report.AvailabilityZones[az], report.OnDemandPrice)
+	}
+	showMaxPriceSuggestion("All zones", report.AllZones, report.OnDemandPrice)
+
+	showCostEstimate(report.Estimate)
+	fmt.Println("")
+}
+
+func showMaxPriceSuggestion(name string, s data.MaxPriceSuggestion, onDemand float64) {
+	if s.Hours == 0 {
+		fmt.Printf("  %-15s no prices\n", name)
+		return
+	}
+
+	share := "on-demand unknown"
+	if onDemand > 0 {
+		share = fmt.Sprintf("%5.1f%% of on-demand", s.MaxPrice/onDemand*100)
+	}
+
+	fmt.Printf("  %-15s $%-10.4g %s, running %.2f%% of %d hours\n",
+		name, s.MaxPrice, share, s.Availability*100, s.Hours)
+}
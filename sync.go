@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+	"github.com/lox/ec2spot/store"
+	"github.com/lox/ec2spot/timerange"
+)
+
+// awsHistoryDays is how far back AWS keeps spot price history
+const awsHistoryDays = 90
+
+func runSyncCommand(args []string) {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	storeFlag := fs.String("store", "ec2spot.db", "Path to the local price history store")
+	parseInstanceTypes := instanceFlags(fs, "Sync a particular instance type")
+	productFlag := fs.String("product", "Linux/UNIX (Amazon VPC)", "Sync a particular product type")
+	regionFlag := fs.String("region", "us-east-1", "Sync a particular region, multiple comma delimited, a group like us-* or all")
+	concurrencyFlag := fs.Int("concurrency", 10, "How many concurrent AWS requests to make")
+	configureClient := clientFlags(fs)
+	fs.Parse(args)
+	configureClient()
+
+	instanceTypes, err := parseInstanceTypes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	regions, err := parseRegions(*regionFlag, instanceTypes, knownRegions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	st, err := store.Open(*storeFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer st.Close()
+
+	err = syncStore(context.Background(), st, syncParams{
+		InstanceTypes: instanceTypes,
+		Regions:       regions,
+		Products:      strings.Split(*productFlag, ","),
+		Concurrency:   *concurrencyFlag,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+type syncParams struct {
+	InstanceTypes []string
+	Regions       []string
+	Products      []string
+	Concurrency   int
+}
+
+// syncStore fetches history since the newest stored price for each region, instance type
+// and product
+func syncStore(ctx context.Context, st *store.Store, params syncParams) error {
+	now := time.Now()
+	oldest := now.AddDate(0, 0, -awsHistoryDays)
+
+	for _, region := range params.Regions {
+		for _, instanceType := range params.InstanceTypes {
+			for _, product := range params.Products {
+				start := st.Latest(region, instanceType, product)
+				if start.Before(oldest) {
+					start = oldest
+				}
+
+				results, g := fetcher.BatchFetch(ctx, params.Concurrency, fetcher.BatchFetchSpec{
+					InstanceTypes: []string{instanceType},
+					Regions:       []string{region},
+					Products:      []string{product},
+					Range:         timerange.Range{start, now},
+				})
+
+				prices := data.SpotPriceSlice{}
+				for price := range results {
+					prices = append(prices, price)
+				}
+
+				if err := g.Wait(); err != nil {
+					return err
+				}
+
+				added, err := st.Append(prices)
+				if err != nil {
+					return err
+				}
+
+				log.Printf("Synced %d new %s prices for %s in %s", added, product, instanceType, region)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+)
+
+type watchParams struct {
+	InstanceTypes     []string
+	Regions           []string
+	AvailabilityZones []string
+	Products          []string
+	Interval          time.Duration
+	Lookback          time.Duration
+	Threshold         float64
+	ThresholdPercent  float64
+}
+
+// validate checks that exactly one kind of threshold is set
+func (p watchParams) validate() error {
+	switch {
+	case p.Threshold > 0 && p.ThresholdPercent > 0:
+		return fmt.Errorf("only one of -threshold or -threshold-percent can be used")
+	case p.Threshold <= 0 && p.ThresholdPercent <= 0:
+		return fmt.Errorf("either -threshold or -threshold-percent is required")
+	}
+	return nil
+}
+
+type alert struct {
+	Region           string    `json:"region"`
+	InstanceType     string    `json:"instance_type"`
+	AvailabilityZone string    `json:"availability_zone"`
+	Product          string    `json:"product"`
+	Price            float64   `json:"price"`
+	Threshold        float64   `json:"threshold"`
+	Above            bool      `json:"above"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+func (a alert) String() string {
+	direction := "below"
+	if a.Above {
+		direction = "above"
+	}
+	return fmt.Sprintf("%s %s %s %s price $%.6g is %s threshold $%.6g at %s",
+		a.Region, a.AvailabilityZone, a.InstanceType, a.Product, a.Price, direction, a.Threshold,
+		a.Timestamp.Format(time.RFC3339))
+}
+
+type alerter interface {
+	Alert(a alert) error
+}
+
+type stdoutAlerter struct{}
+
+func (stdoutAlerter) Alert(a alert) error {
+	_, err := fmt.Println(a.String())
+	return err
+}
+
+// webhookTimeout bounds how long a webhook can hold up the alerts after it
+const webhookTimeout = time.Second * 10
+
+type webhookAlerter struct {
+	URL    string
+	Client *http.Client
+}
+
+func newWebhookAlerter(url string) webhookAlerter {
+	return webhookAlerter{URL: url, Client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (w webhookAlerter) Alert(a alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", w.URL, resp.Status)
+	}
+
+	return nil
+}
+
+// execAlerter runs a command with the alert details in the environment
+type execAlerter struct {
+	Command string
+}
+
+func (e execAlerter) Alert(a alert) error {
+	cmd := exec.Command("/bin/sh", "-c", e.Command)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"EC2SPOT_REGION="+a.Region,
+		"EC2SPOT_INSTANCE_TYPE="+a.InstanceType,
+		"EC2SPOT_AVAILABILITY_ZONE="+a.AvailabilityZone,
+		"EC2SPOT_PRODUCT="+a.Product,
+		fmt.Sprintf("EC2SPOT_PRICE=%.6g", a.Price),
+		fmt.Sprintf("EC2SPOT_THRESHOLD=%.6g", a.Threshold),
+		fmt.Sprintf("EC2SPOT_ABOVE=%v", a.Above),
+		"EC2SPOT_TIMESTAMP="+a.Timestamp.Format(time.RFC3339),
+	)
+	return cmd.Run()
+}
+
+type multiAlerter []alerter
+
+func (m multiAlerter) Alert(a alert) error {
+	for _, al := range m {
+		if err := al.Alert(a); err != nil {
+			log.Printf("Alert failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func runWatchCommand(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	parseInstanceTypes := instanceFlags(fs, "Watch a particular instance type")
+	parseProducts := productFlags(fs, "Watch")
+	regionFlag := fs.String("region", "us-east-1", "Watch a particular region, multiple comma delimited, a group like us-* or all")
+	azsFlag := fs.String("azs", "", "Only include specific availability zones, as letters applied to each region (e.g a,b,c), names or ids")
+	intervalFlag := fs.Duration("interval", time.Minute*5, "How often to poll for new prices")
+	lookbackFlag := fs.Duration("lookback", time.Hour, "How far back to look for the current price on the first poll")
+	thresholdFlag := fs.Float64("threshold", 0, "Alert when a price crosses this absolute price")
+	thresholdPercentFlag := fs.Float64("threshold-percent", 0, "Alert when a price crosses this percentage of the on-demand price")
+	webhookFlag := fs.String("webhook", "", "POST alerts as JSON to this url")
+	execFlag := fs.String("exec", "", "Run this shell command for each alert, with details in EC2SPOT_* environment variables")
+	configureClient := clientFlags(fs)
+	fs.Parse(args)
+	configureClient()
+
+	params := watchParams{
+		Products:         parseProducts(),
+		Interval:         *intervalFlag,
+		Lookback:         *lookbackFlag,
+		Threshold:        *thresholdFlag,
+		ThresholdPercent: *thresholdPercentFlag,
+	}
+
+	if err := params.validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	instanceTypes, err := parseInstanceTypes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	regions, err := parseRegions(*regionFlag, instanceTypes, knownRegions)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	azs, err := parseAvailabilityZones(regions, *azsFlag, fetcher.AvailabilityZones)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	params.InstanceTypes = instanceTypes
+	params.Regions = regions
+	params.AvailabilityZones = azs
+
+	alerters := multiAlerter{stdoutAlerter{}}
+
+	if *webhookFlag != "" {
+		alerters = append(alerters, newWebhookAlerter(*webhookFlag))
+	}
+
+	if *execFlag != "" {
+		alerters = append(alerters, execAlerter{Command: *execFlag})
+	}
+
+	if err := newWatcher(params, alerters).run(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// watchState tracks the latest known price for a region, instance type and availability zone
+type watchState struct {
+	Timestamp time.Time
+	Above     bool
+}
+
+// watcher polls for new prices, alerting when they cross the threshold
+type watcher struct {
+	params  watchParams
+	alerter alerter
+	now     func() time.Time
+	fetch   func(spec fetcher.FetchSpec) (data.SpotPriceSlice, error)
+
+	states map[string]watchState
+	since  map[string]time.Time
+	start  time.Time
+}
+
+func newWatcher(params watchParams, al alerter) *watcher {
+	return &watcher{
+		params:  params,
+		alerter: al,
+		now:     time.Now,
+		fetch:   fetcher.Fetch,
+		states:  map[string]watchState{},
+		since:   map[string]time.Time{},
+	}
+}
+
+// run polls every interval until ctx is done
+func (w *watcher) run(ctx context.Context) error {
+	for {
+		if err := w.poll(); err != nil {
+			return err
+		}
+
+		select {
+		case <-time.After(w.params.Interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll fetches history since the previous poll and alerts on prices that cross the
+// threshold, the first poll looks back over the lookback
+func (w *watcher) poll() error {
+	now := w.now()
+	if w.start.IsZero() {
+		w.start = now.Add(-w.params.Lookback)
+	}
+
+	for _, region := range w.params.Regions {
+		for _, instanceType := range w.params.InstanceTypes {
+			thresholds, err := w.thresholds(region, instanceType)
+			if err != nil {
+				return err
+			}
+
+			// only fetch history since the last successful poll for this pair
+			pair := region + "/" + instanceType
+			if _, ok := w.since[pair]; !ok {
+				w.since[pair] = w.start
+			}
+
+			prices, err := w.fetchSince(region, instanceType, w.since[pair], now)
+			if err != nil {
+				log.Printf("Failed to fetch %s %s: %v", region, instanceType, err)
+				continue
+			}
+			w.since[pair] = now
+
+			for _, price := range prices.SortByTime() {
+				key := price.Region + "/" + price.InstanceType + "/" + price.AvailabilityZone + "/" + price.ProductDescription
+				state, seen := w.states[key]
+				if seen && !price.Timestamp.After(state.Timestamp) {
+					continue
+				}
+
+				threshold, ok := thresholds[price.ProductDescription]
+				if !ok {
+					continue
+				}
+
+				above := price.Price > threshold
+				if (!seen && above) || (seen && above != state.Above) {
+					w.alerter.Alert(alert{
+						Region:           price.Region,
+						InstanceType:     price.InstanceType,
+						AvailabilityZone: price.AvailabilityZone,
+						Product:          price.ProductDescription,
+						Price:            price.Price,
+						Threshold:        threshold,
+						Above:            above,
+						Timestamp:        price.Timestamp,
+					})
+				}
+
+				w.states[key] = watchState{Timestamp: price.Timestamp, Above: above}
+			}
+		}
+	}
+
+	return nil
+}
+
+// thresholds returns the threshold for each product of an instance type in a region
+func (w *watcher) thresholds(region, instanceType string) (map[string]float64, error) {
+	thresholds := map[string]float64{}
+
+	for _, product := range w.params.Products {
+		if w.params.ThresholdPercent <= 0 {
+			thresholds[product] = w.params.Threshold
+			continue
+		}
+
+		info, err := data.GetInstanceTypeInfo(region, instanceType, product)
+		if err != nil {
+			return nil, err
+		}
+		if info.Price == 0 {
+			return nil, fmt.Errorf("no on-demand price is known for %s %s in %s, use -threshold instead",
+				product, instanceType, region)
+		}
+		thresholds[product] = info.Price * w.params.ThresholdPercent / 100
+	}
+
+	return thresholds, nil
+}
+
+func (w *watcher) fetchSince(region, instanceType string, start, end time.Time) (data.SpotPriceSlice, error) {
+	azs := []string{}
+	for _, az := range w.params.AvailabilityZones {
+		if data.RegionFromAvailabilityZone(az) == region {
+			azs = append(azs, az)
+		}
+	}
+	if len(azs) == 0 {
+		azs = []string{""}
+	}
+
+	prices := data.SpotPriceSlice{}
+	for _, az := range azs {
+		result, err := w.fetch(fetcher.FetchSpec{
+			Region:              region,
+			Start:               start,
+			End:                 end,
+			InstanceTypes:       []string{instanceType},
+			ProductDescriptions: w.params.Products,
+			AvailabilityZone:    az,
+		})
+		if err != nil {
+			return nil, err
+		}
+		prices = append(prices, result...)
+	}
+
+	return prices, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/data"
+	"github.com/lox/ec2spot/fetcher"
+)
+
+type recordingAlerter []alert
+
+func (r *recordingAlerter) Alert(a alert) error {
+	*r = append(*r, a)
+	return nil
+}
+
+// fakeHistory returns the prices with timestamps inside each fetched range
+type fakeHistory data.SpotPriceSlice
+
+func (h fakeHistory) Fetch(spec fetcher.FetchSpec) (data.SpotPriceSlice, error) {
+	result := data.SpotPriceSlice{}
+	for _, p := range h {
+		if !p.Timestamp.Before(spec.Start) && p.Timestamp.Before(spec.End) {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+func watchPrice(ts time.Time, price float64) data.SpotPrice {
+	return data.SpotPrice{
+		Region:             "us-east-1",
+		InstanceType:       "c4.large",
+		ProductDescription: "Linux/UNIX",
+		AvailabilityZone:   "us-east-1a",
+		Price:              price,
+		Timestamp:          ts,
+	}
+}
+
+func newTestWatcher(history fakeHistory, now *time.Time) (*watcher, *recordingAlerter) {
+	alerts := &recordingAlerter{}
+	w := newWatcher(watchParams{
+		InstanceTypes: []string{"c4.large"},
+		Regions:       []string{"us-east-1"},
+		Products:      []string{"Linux/UNIX"},
+		Interval:      time.Minute,
+		Lookback:      time.Hour,
+		Threshold:     0.05,
+	}, alerts)
+	w.now = func() time.Time { return *now }
+	w.fetch = history.Fetch
+	return w, alerts
+}
+
+func TestWatcherAlertsOnCrossingRecoveryAndRecrossing(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+
+	history := fakeHistory{
+		watchPrice(start.Add(-30*time.Minute), 0.03),
+		watchPrice(start.Add(1*time.Minute), 0.06),
+		watchPrice(start.Add(2*time.Minute), 0.07),
+		watchPrice(start.Add(3*time.Minute), 0.04),
+		watchPrice(start.Add(4*time.Minute), 0.08),
+	}
+
+	w, alerts := newTestWatcher(history, &now)
+
+	expected := []int{0, 1, 1, 2, 3}
+	for i := range expected {
+		now = start.Add(time.Duration(i)*time.Minute + time.Second)
+		if err := w.poll(); err != nil {
+			t.Fatal(err)
+		}
+		if len(*alerts) != expected[i] {
+			t.Fatalf("Expected %d alerts after poll %d, got %d", expected[i], i, len(*alerts))
+		}
+	}
+
+	above := []bool{true, false, true}
+	for i, a := range *alerts {
+		if a.Above != above[i] {
+			t.Fatalf("Expected alert %d to have Above %v, got %v", i, above[i], a.Above)
+		}
+		if a.Threshold != 0.05 {
+			t.Fatalf("Expected threshold 0.05, got %v", a.Threshold)
+		}
+	}
+	if (*alerts)[2].Price != 0.08 {
+		t.Fatalf("Expected re-alert at 0.08, got %v", (*alerts)[2].Price)
+	}
+}
+
+func TestWatcherAlertsOnFirstPriceAboveThreshold(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	w, alerts := newTestWatcher(fakeHistory{
+		watchPrice(now.Add(-10*time.Minute), 0.09),
+	}, &now)
+
+	for i := 0; i < 2; i++ {
+		if err := w.poll(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(*alerts) != 1 || !(*alerts)[0].Above {
+		t.Fatalf("Expected a single alert above threshold, got %v", *alerts)
+	}
+}
+
+func TestWatchParamsRejectsBothThresholds(t *testing.T) {
+	if err := (watchParams{Threshold: 0.1, ThresholdPercent: 50}).validate(); err == nil {
+		t.Fatalf("Expected an error with both thresholds set")
+	}
+	if err := (watchParams{}).validate(); err == nil {
+		t.Fatalf("Expected an error with no threshold set")
+	}
+	if err := (watchParams{ThresholdPercent: 50}).validate(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestWatcherUsesEachProductsThreshold(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	windows := watchPrice(now.Add(-10*time.Minute), 0.15)
+	windows.ProductDescription = "Windows"
+
+	w, alerts := newTestWatcher(fakeHistory{watchPrice(now.Add(-10*time.Minute), 0.15), windows}, &now)
+	w.params.Products = []string{"Linux/UNIX", "Windows"}
+	w.params.Threshold = 0
+	w.params.ThresholdPercent = 100
+
+	if err := w.poll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// $0.15 is above the linux on-demand price but below the windows one
+	if len(*alerts) != 1 || (*alerts)[0].Product != "Linux/UNIX" {
+		t.Fatalf("Expected a single alert for Linux/UNIX, got %v", *alerts)
+	}
+}
+
+func TestWebhookAlerterFailsOnErrorsAndTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(time.Millisecond * 100)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	al := newWebhookAlerter(srv.URL)
+	if err := al.Alert(alert{}); err == nil {
+		t.Fatal("Expected an error for a 500 response")
+	}
+
+	al = newWebhookAlerter(srv.URL + "/slow")
+	al.Client.Timeout = time.Millisecond * 10
+	if err := al.Alert(alert{}); err == nil {
+		t.Fatal("Expected an error when the webhook times out")
+	}
+}
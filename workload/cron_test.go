@@ -0,0 +1,39 @@
+package workload_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/workload"
+)
+
+func TestCronMatches(t *testing.T) {
+	c, err := workload.ParseCron("*/30 9-17 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2017-07-03 is a monday
+	for _, tc := range []struct {
+		Time     time.Time
+		Expected bool
+	}{
+		{time.Date(2017, time.July, 3, 9, 0, 0, 0, time.UTC), true},
+		{time.Date(2017, time.July, 3, 17, 30, 0, 0, time.UTC), true},
+		{time.Date(2017, time.July, 3, 9, 15, 0, 0, time.UTC), false},
+		{time.Date(2017, time.July, 3, 18, 0, 0, 0, time.UTC), false},
+		{time.Date(2017, time.July, 2, 9, 0, 0, 0, time.UTC), false},
+	} {
+		if matched := c.Matches(tc.Time); matched != tc.Expected {
+			t.Fatalf("Expected %v for %s, got %v", tc.Expected, tc.Time, matched)
+		}
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* 5-2 * * *", "*/0 * * * *"} {
+		if _, err := workload.ParseCron(expr); err == nil {
+			t.Fatalf("Expected an error for %q", expr)
+		}
+	}
+}
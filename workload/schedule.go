@@ -0,0 +1,130 @@
+package workload
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lox/ec2spot/timerange"
+)
+
+// Duration is a job duration and how often jobs take that long relative to the others
+type Duration struct {
+	Duration time.Duration
+	Weight   float64
+}
+
+// Distribution is the spread of how long jobs take
+type Distribution []Duration
+
+// ParseDistribution parses a single duration like 8h, or comma delimited durations with
+// weights like 10m:70,30m:25,2h:5
+func ParseDistribution(s string) (Distribution, error) {
+	dist := Distribution{}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		weight := 1.0
+		if idx := strings.Index(part, ":"); idx != -1 {
+			var err error
+			if weight, err = strconv.ParseFloat(part[idx+1:], 64); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight in %q", part)
+			}
+			part = part[:idx]
+		}
+
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid job duration %q", part)
+		}
+
+		dist = append(dist, Duration{Duration: d, Weight: weight})
+	}
+
+	if len(dist) == 0 {
+		return nil, fmt.Errorf("no job durations in %q", s)
+	}
+
+	return dist, nil
+}
+
+// Max returns the longest duration
+func (d Distribution) Max() time.Duration {
+	var max time.Duration
+	for _, wd := range d {
+		if wd.Duration > max {
+			max = wd.Duration
+		}
+	}
+	return max
+}
+
+// Schedule is a workload that starts a job each time a cron expression matches, with each
+// job taking a duration from a distribution
+type Schedule struct {
+	Cron      *Cron
+	Durations Distribution
+}
+
+// Usage returns the expected instance hours used during tr by jobs started on schedule,
+// including jobs started before tr that were still running
+func (s Schedule) Usage(tr timerange.Range) float64 {
+	return s.Plan(tr).Usage(tr)
+}
+
+// Plan finds every job start that affects usage during tr once, so that usage for many
+// smaller ranges within it, like each hour, doesn't match the schedule again each time
+func (s Schedule) Plan(tr timerange.Range) PlannedSchedule {
+	p := PlannedSchedule{schedule: s, tr: tr}
+
+	for _, wd := range s.Durations {
+		p.totalWeight += wd.Weight
+	}
+
+	for t := tr[0].Add(-s.Durations.Max()).Truncate(time.Minute); t.Before(tr[1]); t = t.Add(time.Minute) {
+		if s.Cron.Matches(t) {
+			p.starts = append(p.starts, t)
+		}
+	}
+
+	return p
+}
+
+// PlannedSchedule is a Schedule with its job starts found for a range
+type PlannedSchedule struct {
+	schedule    Schedule
+	tr          timerange.Range
+	starts      []time.Time
+	totalWeight float64
+}
+
+// Usage returns the expected instance hours used during tr, which should be within the
+// planned range. Ranges outside it are matched against the schedule again.
+func (p PlannedSchedule) Usage(tr timerange.Range) float64 {
+	if tr[0].Before(p.tr[0]) || tr[1].After(p.tr[1]) {
+		return p.schedule.Plan(tr).Usage(tr)
+	}
+
+	earliest := tr[0].Add(-p.schedule.Durations.Max())
+	first := sort.Search(len(p.starts), func(i int) bool {
+		return !p.starts[i].Before(earliest)
+	})
+
+	var used float64
+	for _, t := range p.starts[first:] {
+		if !t.Before(tr[1]) {
+			break
+		}
+		for _, wd := range p.schedule.Durations {
+			used += overlap(t, t.Add(wd.Duration), tr).Hours() * wd.Weight / p.totalWeight
+		}
+	}
+
+	return used
+}
@@ -0,0 +1,112 @@
+// Package workload describes when instances actually run, so costs can be estimated over
+// the hours a workload uses rather than around the clock
+package workload
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lox/ec2spot/timerange"
+)
+
+// Workload reports how much an instance is used over time
+type Workload interface {
+	// Usage returns the instance hours used during tr, which is more than the length of tr
+	// when several instances run at once
+	Usage(tr timerange.Range) float64
+}
+
+// Run is a period where an instance was running
+type Run struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Runs is a workload made of individual runs
+type Runs []Run
+
+// Usage returns the instance hours runs overlap with tr
+func (r Runs) Usage(tr timerange.Range) float64 {
+	var used time.Duration
+	for _, run := range r {
+		used += overlap(run.Start, run.End, tr)
+	}
+	return used.Hours()
+}
+
+func overlap(start, end time.Time, tr timerange.Range) time.Duration {
+	if start.Before(tr[0]) {
+		start = tr[0]
+	}
+	if end.After(tr[1]) {
+		end = tr[1]
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// LoadUsage reads runs from a csv file, see ReadUsageCSV
+func LoadUsage(path string) (Runs, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	runs, err := ReadUsageCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage %s: %v", path, err)
+	}
+	return runs, nil
+}
+
+// ReadUsageCSV reads runs from csv rows of a start time followed by either an end time or
+// the number of hours used from the start, e.g a billing export of hourly usage. Times are
+// RFC3339 and an optional header row is skipped.
+func ReadUsageCSV(r io.Reader) (Runs, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := Runs{}
+	for idx, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected a start and an end or hours", idx+1)
+		}
+
+		start, err := time.Parse(time.RFC3339, strings.TrimSpace(record[0]))
+		if err != nil {
+			if idx == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %v", idx+1, err)
+		}
+
+		value := strings.TrimSpace(record[1])
+		end, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			hours, parseErr := strconv.ParseFloat(value, 64)
+			if parseErr != nil {
+				return nil, fmt.Errorf("line %d: %q isn't an end time or hours", idx+1, value)
+			}
+			end = start.Add(time.Duration(hours * float64(time.Hour)))
+		}
+
+		runs = append(runs, Run{Start: start, End: end})
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Start.Before(runs[j].Start)
+	})
+
+	return runs, nil
+}
@@ -0,0 +1,83 @@
+package workload_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lox/ec2spot/timerange"
+	"github.com/lox/ec2spot/workload"
+)
+
+var t1 = time.Date(2017, time.July, 3, 0, 0, 0, 0, time.UTC)
+
+func TestReadUsageCSV(t *testing.T) {
+	runs, err := workload.ReadUsageCSV(strings.NewReader(`start,end
+2017-07-03T01:00:00Z,2017-07-03T01:30:00Z
+2017-07-03T00:00:00Z,2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage := runs.Usage(timerange.Range{t1.Add(time.Hour), t1.Add(time.Hour * 2)}); usage != 1.5 {
+		t.Fatalf("Expected 1.5 instance hours from overlapping runs, got %v", usage)
+	}
+}
+
+func TestScheduleUsage(t *testing.T) {
+	c, err := workload.ParseCron("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	durations, err := workload.ParseDistribution("30m:1,2h:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := workload.Schedule{Cron: c, Durations: durations}
+
+	// a 9am job runs for half of the 9am hour or all of it, equally likely
+	if usage := s.Usage(timerange.Range{t1.Add(time.Hour * 9), t1.Add(time.Hour * 10)}); usage != 0.75 {
+		t.Fatalf("Expected 0.75 instance hours at 9am, got %v", usage)
+	}
+
+	// only the longer jobs are still running at 10am
+	if usage := s.Usage(timerange.Range{t1.Add(time.Hour * 10), t1.Add(time.Hour * 11)}); usage != 0.5 {
+		t.Fatalf("Expected 0.5 instance hours at 10am, got %v", usage)
+	}
+
+	if usage := s.Usage(timerange.Range{t1, t1.AddDate(0, 0, 1)}); usage != 1.25 {
+		t.Fatalf("Expected 1.25 instance hours a day, got %v", usage)
+	}
+}
+
+func TestPlannedScheduleMatchesSchedule(t *testing.T) {
+	c, err := workload.ParseCron("*/20 9-17 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	durations, err := workload.ParseDistribution("10m:70,30m:25,2h:5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := workload.Schedule{Cron: c, Durations: durations}
+	week := timerange.Range{t1, t1.AddDate(0, 0, 7)}
+	planned := s.Plan(week)
+
+	for _, hour := range week.Split(time.Hour) {
+		if expected, got := s.Usage(hour), planned.Usage(hour); math.Abs(expected-got) > 1e-9 {
+			t.Fatalf("Expected %v instance hours at %v, got %v", expected, hour[0], got)
+		}
+	}
+
+	// outside the planned range falls back to the schedule
+	next := timerange.Range{week[1].Add(time.Hour * 9), week[1].Add(time.Hour * 10)}
+	if expected, got := s.Usage(next), planned.Usage(next); expected == 0 || math.Abs(expected-got) > 1e-9 {
+		t.Fatalf("Expected %v instance hours outside the planned range, got %v", expected, got)
+	}
+}